@@ -6,6 +6,11 @@ CREATE INDEX `kind_idx` ON `isuumo`.`chair`(`kind`) USING BTREE;
 CREATE INDEX `color_idx` ON `isuumo`.`chair`(`color`) USING BTREE;
 CREATE INDEX `stock_idx` ON `isuumo`.`chair`(`stock`) USING BTREE;
 CREATE INDEX `chair_popularity_desc_id_idx` ON `isuumo`.`chair`(`popularity_desc`, `id`) USING BTREE;
+CREATE INDEX `chair_price_id_idx` ON `isuumo`.`chair`(`price`, `id`) USING BTREE;
+CREATE INDEX `chair_price_desc_id_idx` ON `isuumo`.`chair`(`price_desc`, `id`) USING BTREE;
+CREATE INDEX `chair_size_id_idx` ON `isuumo`.`chair`(`size`, `id`) USING BTREE;
+CREATE INDEX `chair_size_desc_id_idx` ON `isuumo`.`chair`(`size_desc`, `id`) USING BTREE;
+CREATE INDEX `chair_id_desc_idx` ON `isuumo`.`chair`(`id_desc`) USING BTREE;
 
 CREATE INDEX `door_height_idx` ON `isuumo`.`estate`(`door_height`) USING BTREE;
 CREATE INDEX `door_width_idx` ON `isuumo`.`estate`(`door_width`) USING BTREE;
@@ -15,3 +20,4 @@ CREATE INDEX `popularity_idx` ON `isuumo`.`estate`(`popularity`) USING BTREE;
 CREATE INDEX `latitude_idx` ON `isuumo`.`estate`(`latitude`) USING BTREE;
 CREATE INDEX `longitude_idx` ON `isuumo`.`estate`(`longitude`) USING BTREE;
 CREATE INDEX `estate_popularity_desc_id_idx` ON `isuumo`.`estate`(`popularity_desc`, `id`) USING BTREE;
+CREATE SPATIAL INDEX `point_idx` ON `isuumo`.`estate`(`point`);
@@ -3,6 +3,7 @@ CREATE DATABASE isuumo;
 
 DROP TABLE IF EXISTS isuumo.estate;
 DROP TABLE IF EXISTS isuumo.chair;
+DROP TABLE IF EXISTS isuumo.applied_jobs;
 
 CREATE TABLE isuumo.estate
 (
@@ -18,7 +19,14 @@ CREATE TABLE isuumo.estate
     door_width      INTEGER                  NOT NULL,
     features        VARCHAR(64)              NOT NULL,
     popularity      INTEGER                  NOT NULL,
-    popularity_desc INTEGER AS (-popularity) NOT NULL
+    popularity_desc INTEGER AS (-popularity) NOT NULL,
+    point           POINT AS (POINT(latitude, longitude)) STORED NOT NULL
+);
+
+-- write queue jobs already committed, see applyWriteJob
+CREATE TABLE isuumo.applied_jobs
+(
+    id VARCHAR(32) NOT NULL PRIMARY KEY
 );
 
 CREATE TABLE isuumo.chair
@@ -36,5 +44,9 @@ CREATE TABLE isuumo.chair
     kind            VARCHAR(64)              NOT NULL,                   
     popularity      INTEGER                  NOT NULL,                   
     popularity_desc INTEGER AS (-popularity) NOT NULL,
+    price_desc      INTEGER AS (-price) NOT NULL,
+    size            INTEGER AS (width * height * depth) NOT NULL,
+    size_desc       INTEGER AS (-(width * height * depth)) NOT NULL,
+    id_desc         INTEGER AS (-id) NOT NULL,
     stock           INTEGER                  NOT NULL                    
 );
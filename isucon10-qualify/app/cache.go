@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/spf13/cast"
+)
+
+// cache key
+const (
+	CacheKeyChairID  = "chair:id:"
+	CacheKeyEstateID = "estate:id:"
+)
+
+// cache backends
+const (
+	cacheBackendRedis  = "redis"
+	cacheBackendMemory = "memory"
+)
+
+var (
+	errCacheMiss    = errors.New("cache: key not found")
+	errCacheSoldOut = errors.New("cache: stock sold out")
+)
+
+var cache Cache
+
+// Cache the operations the app needs from its cache, implemented by Redis
+// and by an in-process store
+type Cache interface {
+	// Get returns errCacheMiss when the key does not exist
+	Get(ctx context.Context, key string) (string, error)
+	// MGet returns the keys that exist
+	MGet(ctx context.Context, keys ...string) (map[string]string, error)
+	Set(ctx context.Context, key string, value interface{}) error
+	Del(ctx context.Context, keys ...string) error
+	// Keys returns the keys matching a glob pattern
+	Keys(ctx context.Context, pattern string) ([]string, error)
+	FlushAll(ctx context.Context) error
+	Pipeline() CachePipeline
+
+	SAdd(ctx context.Context, key string, members ...interface{}) error
+	SRem(ctx context.Context, key string, members ...interface{}) error
+	SMembers(ctx context.Context, key string) ([]string, error)
+
+	ZAdd(ctx context.Context, key string, members ...CacheZ) error
+	ZRem(ctx context.Context, key string, members ...interface{}) error
+	// ZInter the members in every key, sets and sorted sets alike
+	ZInter(ctx context.Context, keys ...string) ([]string, error)
+
+	// ReserveStock atomically decrements the stock under stockKey and deletes
+	// rowKey once it reaches zero, returning the deleted value. Returns
+	// errCacheMiss for an unknown stock and errCacheSoldOut when there is
+	// none left.
+	ReserveStock(ctx context.Context, stockKey, rowKey string) (int64, string, error)
+	// ReleaseStock undoes ReserveStock, it increments the stock and sets
+	// rowKey to row unless row is empty
+	ReleaseStock(ctx context.Context, stockKey, rowKey, row string) error
+}
+
+// CachePipeline buffers writes until Exec
+type CachePipeline interface {
+	Set(key string, value interface{})
+	Del(keys ...string)
+	SAdd(key string, members ...interface{})
+	SRem(key string, members ...interface{})
+	ZAdd(key string, members ...CacheZ)
+	ZRem(key string, members ...interface{})
+	// ZRangeStoreByScore stores the members of src scored within [min, max]
+	// in the sorted set dst, use math.Inf for open ends
+	ZRangeStoreByScore(dst, src string, min, max float64)
+	// ZUnionStore stores the members of the sets and sorted sets in the
+	// sorted set dst
+	ZUnionStore(dst string, keys ...string)
+	// Expire deletes the key after ttl
+	Expire(key string, ttl time.Duration)
+	Exec(ctx context.Context) error
+}
+
+// CacheZ sorted set member
+type CacheZ struct {
+	Score  float64
+	Member interface{}
+}
+
+func cacheKey(arr ...string) string {
+	return strings.Join(arr, ":")
+}
+
+// initCache picks the backend from CACHE_BACKEND, by default Redis when
+// REDIS_ADDR is set and the in-process cache otherwise
+func initCache() {
+	backend := os.Getenv("CACHE_BACKEND")
+	if backend == "" {
+		backend = cacheBackendMemory
+		if os.Getenv("REDIS_ADDR") != "" {
+			backend = cacheBackendRedis
+		}
+	}
+
+	switch backend {
+	case cacheBackendRedis:
+		cache = newRedisCache(os.Getenv("REDIS_ADDR"))
+	case cacheBackendMemory:
+		cache = newMemoryCache()
+	default:
+		logger.Fatalf("unknown CACHE_BACKEND: %s", backend)
+	}
+	logger.Infof("cache backend: %s", backend)
+}
+
+func tablesCache() {
+	chairs := make([]*Chair, 0, 3200)
+	if err := db.Select(&chairs, "SELECT "+chairSelectColumns+" FROM chair"); err != nil {
+		logger.Errorf("chair table query err: %s", err)
+	}
+
+	estates := make([]*Estate, 0, 3200)
+	if err := db.Select(&estates, "SELECT "+estateSelectColumns+" FROM estate"); err != nil {
+		logger.Errorf("estate table query err: %s", err)
+	}
+
+	cacheTables(chairs, estates)
+}
+
+// cacheTables fills the cache and the in-memory indexes from the table rows
+func cacheTables(chairs []*Chair, estates []*Estate) {
+	cacheRows(chairs, estates)
+	buildIndexes(chairs, estates)
+}
+
+// cacheRows fills the cache from the table rows
+func cacheRows(chairs []*Chair, estates []*Estate) {
+	pipe := cache.Pipeline()
+	for _, row := range chairs {
+		if row.Stock > 0 {
+			cacheRow(CacheKeyChairID, row.ID, row, pipe)
+		}
+	}
+	cacheChairStock(pipe, chairs...)
+	if err := pipe.Exec(context.Background()); err != nil {
+		logger.Errorf("cache chair err: %s", err)
+	}
+
+	if err := cacheChair(chairs...); err != nil {
+		logger.Errorf("cache chair err: %s", err)
+	}
+
+	pipe = cache.Pipeline()
+	for _, row := range estates {
+		cacheRow(CacheKeyEstateID, row.ID, row, pipe)
+	}
+	if err := pipe.Exec(context.Background()); err != nil {
+		logger.Errorf("cache estate err: %s", err)
+	}
+}
+
+// buildIndexes builds the in-memory indexes from the table rows
+func buildIndexes(chairs []*Chair, estates []*Estate) {
+	chairIndex.Load(chairs)
+	chairFitKeys.Load(chairs)
+	lowPricedChairs.Load(chairLowPriced(chairs...))
+	chairTextIndex.Load(chairTextDocs(chairs...))
+
+	estateIndex.Load(estates)
+	estateGeoIndex.Load(estates)
+	estateFitIndex.Load(estates)
+	lowPricedEstates.Load(estateLowPriced(estates...))
+	estateTextIndex.Load(estateTextDocs(estates...))
+
+	responseCache.Invalidate(responseChair, responseEstate)
+}
+
+func cacheRow(prefix string, id interface{}, row interface{}, r CachePipeline) {
+	val, _ := jsoniter.MarshalToString(row)
+	if r != nil {
+		r.Set(prefix+cast.ToString(id), val)
+		return
+	}
+	if err := cache.Set(context.Background(), prefix+cast.ToString(id), val); err != nil {
+		logger.Errorf("cache row err: %s", err)
+	}
+}
+
+func fetchCacheRow(prefix string, id interface{}, data interface{}) error {
+	val, err := cache.Get(context.Background(), prefix+cast.ToString(id))
+	if err != nil {
+		return err
+	}
+	if err := jsoniter.UnmarshalFromString(val, data); err != nil {
+		return err
+	}
+	return nil
+}
+
+// cacheChair keeps the sets and sorted sets cacheChairSearcher intersects,
+// chair:instock holds the chairs that can be sold
+func cacheChair(arr ...*Chair) error {
+	pipe := cache.Pipeline()
+	for _, row := range arr {
+		if row.Stock <= 0 {
+			pipe.SRem(cacheKey("chair", "instock"), row.ID)
+			continue
+		}
+		pipe.SAdd(cacheKey("chair", "instock"), row.ID)
+		pipe.SAdd(cacheKey("chair", "color", row.Color), row.ID)
+		pipe.SAdd(cacheKey("chair", "kind", row.Kind), row.ID)
+		for _, f := range chairSearchCondition.Feature.List {
+			if strings.Contains(row.Features, f) {
+				pipe.SAdd(cacheKey("chair", "feature", f), row.ID)
+			}
+		}
+		pipe.ZAdd(cacheKey("chair", "price"), CacheZ{
+			Score:  float64(row.Price),
+			Member: row.ID,
+		})
+		pipe.ZAdd(cacheKey("chair", "height"), CacheZ{
+			Score:  float64(row.Height),
+			Member: row.ID,
+		})
+		pipe.ZAdd(cacheKey("chair", "width"), CacheZ{
+			Score:  float64(row.Width),
+			Member: row.ID,
+		})
+		pipe.ZAdd(cacheKey("chair", "depth"), CacheZ{
+			Score:  float64(row.Depth),
+			Member: row.ID,
+		})
+		pipe.Set(cacheKey("chair", "popularity", cast.ToString(row.ID)), row.Popularity)
+	}
+	return pipe.Exec(context.Background())
+}
+
+func cacheChairStock(r CachePipeline, arr ...*Chair) {
+	for _, row := range arr {
+		r.Set(cacheKey("chair", "stock", cast.ToString(row.ID)), row.Stock)
+	}
+}
+
+var (
+	errChairNotFound = errors.New("chair not found")
+	errChairSoldOut  = errors.New("chair sold out")
+)
+
+// chairReservation one chair taken by reserveChair, Row is the cached
+// detail of the last one sold
+type chairReservation struct {
+	ID   int64
+	Left int64
+	Row  string
+}
+
+// reserveChair takes one chair from the stock, sold out chairs are removed from search
+func reserveChair(id int64) (*chairReservation, error) {
+	left, row, err := cache.ReserveStock(context.Background(),
+		cacheKey("chair", "stock", cast.ToString(id)),
+		CacheKeyChairID+cast.ToString(id),
+	)
+	switch err {
+	case nil:
+	case errCacheMiss:
+		return nil, errChairNotFound
+	case errCacheSoldOut:
+		return nil, errChairSoldOut
+	default:
+		return nil, err
+	}
+
+	if left <= 0 {
+		chairIndex.Remove(id)
+		lowPricedChairs.Remove(id)
+		chairTextIndex.Remove(id)
+		responseCache.Invalidate(responseChair)
+		if err := cache.SRem(context.Background(), cacheKey("chair", "instock"), id); err != nil {
+			logger.Errorf("cache chair instock err: %s, id: %v", err, id)
+		}
+	}
+	return &chairReservation{ID: id, Left: left, Row: row}, nil
+}
+
+// cancelChairReservation puts the chair back, used when the purchase could
+// not be journaled so the cache does not run ahead of MySQL
+func cancelChairReservation(r *chairReservation) error {
+	if err := cache.ReleaseStock(context.Background(),
+		cacheKey("chair", "stock", cast.ToString(r.ID)),
+		CacheKeyChairID+cast.ToString(r.ID),
+		r.Row,
+	); err != nil {
+		return err
+	}
+	if r.Left > 0 {
+		return nil
+	}
+
+	chair := &Chair{}
+	if err := jsoniter.UnmarshalFromString(r.Row, chair); err != nil {
+		return err
+	}
+	// the cached row keeps the stock it was cached with, one is back now
+	chair.Stock = 1
+	chairIndex.Add(chair)
+	lowPricedChairs.Add(chairLowPriced(chair)...)
+	chairTextIndex.Add(chairTextDocs(chair)...)
+	responseCache.Invalidate(responseChair)
+	return cacheChair(chair)
+}
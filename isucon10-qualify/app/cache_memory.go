@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+)
+
+// memoryCache in-process Cache, for running without Redis
+type memoryCache struct {
+	mu      sync.RWMutex
+	strings map[string]string
+	sets    map[string]map[string]struct{}
+	zsets   map[string]map[string]float64
+}
+
+func newMemoryCache() *memoryCache {
+	mc := &memoryCache{}
+	mc.reset()
+	return mc
+}
+
+func (mc *memoryCache) reset() {
+	mc.strings = make(map[string]string)
+	mc.sets = make(map[string]map[string]struct{})
+	mc.zsets = make(map[string]map[string]float64)
+}
+
+func (mc *memoryCache) Get(ctx context.Context, key string) (string, error) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	val, ok := mc.strings[key]
+	if !ok {
+		return "", errCacheMiss
+	}
+	return val, nil
+}
+
+func (mc *memoryCache) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	res := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if val, ok := mc.strings[key]; ok {
+			res[key] = val
+		}
+	}
+	return res, nil
+}
+
+func (mc *memoryCache) Set(ctx context.Context, key string, value interface{}) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.set(key, value)
+	return nil
+}
+
+func (mc *memoryCache) set(key string, value interface{}) {
+	mc.strings[key] = cast.ToString(value)
+}
+
+func (mc *memoryCache) Del(ctx context.Context, keys ...string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.del(keys...)
+	return nil
+}
+
+func (mc *memoryCache) del(keys ...string) {
+	for _, key := range keys {
+		delete(mc.strings, key)
+		delete(mc.sets, key)
+		delete(mc.zsets, key)
+	}
+}
+
+func (mc *memoryCache) Keys(ctx context.Context, pattern string) ([]string, error) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	keys := make([]string, 0)
+	match := func(key string) {
+		if ok, _ := path.Match(pattern, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	for key := range mc.strings {
+		match(key)
+	}
+	for key := range mc.sets {
+		match(key)
+	}
+	for key := range mc.zsets {
+		match(key)
+	}
+	return keys, nil
+}
+
+func (mc *memoryCache) FlushAll(ctx context.Context) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.reset()
+	return nil
+}
+
+func (mc *memoryCache) Pipeline() CachePipeline {
+	return &memoryPipeline{cache: mc}
+}
+
+func (mc *memoryCache) SAdd(ctx context.Context, key string, members ...interface{}) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.sadd(key, members...)
+	return nil
+}
+
+func (mc *memoryCache) sadd(key string, members ...interface{}) {
+	set, ok := mc.sets[key]
+	if !ok {
+		set = make(map[string]struct{})
+		mc.sets[key] = set
+	}
+	for _, m := range members {
+		set[cast.ToString(m)] = struct{}{}
+	}
+}
+
+func (mc *memoryCache) SRem(ctx context.Context, key string, members ...interface{}) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.srem(key, members...)
+	return nil
+}
+
+func (mc *memoryCache) srem(key string, members ...interface{}) {
+	set := mc.sets[key]
+	for _, m := range members {
+		delete(set, cast.ToString(m))
+	}
+	if len(set) == 0 {
+		delete(mc.sets, key)
+	}
+}
+
+func (mc *memoryCache) SMembers(ctx context.Context, key string) ([]string, error) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	members := make([]string, 0, len(mc.sets[key]))
+	for m := range mc.sets[key] {
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+func (mc *memoryCache) ZAdd(ctx context.Context, key string, members ...CacheZ) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.zadd(key, members...)
+	return nil
+}
+
+func (mc *memoryCache) zadd(key string, members ...CacheZ) {
+	zset, ok := mc.zsets[key]
+	if !ok {
+		zset = make(map[string]float64)
+		mc.zsets[key] = zset
+	}
+	for _, m := range members {
+		zset[cast.ToString(m.Member)] = m.Score
+	}
+}
+
+func (mc *memoryCache) ZRem(ctx context.Context, key string, members ...interface{}) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.zrem(key, members...)
+	return nil
+}
+
+func (mc *memoryCache) zrem(key string, members ...interface{}) {
+	zset := mc.zsets[key]
+	for _, m := range members {
+		delete(zset, cast.ToString(m))
+	}
+	if len(zset) == 0 {
+		delete(mc.zsets, key)
+	}
+}
+
+// scores the members of the set or sorted set key, set members score 1 as
+// in Redis
+func (mc *memoryCache) scores(key string) map[string]float64 {
+	if zset, ok := mc.zsets[key]; ok {
+		return zset
+	}
+	scores := make(map[string]float64, len(mc.sets[key]))
+	for m := range mc.sets[key] {
+		scores[m] = 1
+	}
+	return scores
+}
+
+func (mc *memoryCache) ZInter(ctx context.Context, keys ...string) ([]string, error) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	members := make([]string, 0)
+	if len(keys) == 0 {
+		return members, nil
+	}
+	sets := make([]map[string]float64, 0, len(keys))
+	for _, key := range keys {
+		sets = append(sets, mc.scores(key))
+	}
+	for m := range sets[0] {
+		in := true
+		for _, set := range sets[1:] {
+			if _, ok := set[m]; !ok {
+				in = false
+				break
+			}
+		}
+		if in {
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+// zstore replaces dst, an empty result deletes it as in Redis
+func (mc *memoryCache) zstore(dst string, zset map[string]float64) {
+	mc.del(dst)
+	if len(zset) > 0 {
+		mc.zsets[dst] = zset
+	}
+}
+
+func (mc *memoryCache) zrangestore(dst, src string, min, max float64) {
+	zset := make(map[string]float64)
+	for m, score := range mc.zsets[src] {
+		if score >= min && score <= max {
+			zset[m] = score
+		}
+	}
+	mc.zstore(dst, zset)
+}
+
+func (mc *memoryCache) zunionstore(dst string, keys ...string) {
+	zset := make(map[string]float64)
+	for _, key := range keys {
+		for m, score := range mc.scores(key) {
+			zset[m] += score
+		}
+	}
+	mc.zstore(dst, zset)
+}
+
+func (mc *memoryCache) ReserveStock(ctx context.Context, stockKey, rowKey string) (int64, string, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	val, ok := mc.strings[stockKey]
+	if !ok {
+		return 0, "", errCacheMiss
+	}
+	stock := cast.ToInt64(val)
+	if stock <= 0 {
+		return 0, "", errCacheSoldOut
+	}
+	stock--
+	mc.set(stockKey, stock)
+	var row string
+	if stock <= 0 {
+		row = mc.strings[rowKey]
+		mc.del(rowKey)
+	}
+	return stock, row, nil
+}
+
+func (mc *memoryCache) ReleaseStock(ctx context.Context, stockKey, rowKey, row string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.set(stockKey, cast.ToInt64(mc.strings[stockKey])+1)
+	if row != "" {
+		mc.set(rowKey, row)
+	}
+	return nil
+}
+
+// memoryPipeline applies the buffered writes under one lock
+type memoryPipeline struct {
+	cache *memoryCache
+	ops   []func()
+}
+
+func (mp *memoryPipeline) Set(key string, value interface{}) {
+	mp.ops = append(mp.ops, func() { mp.cache.set(key, value) })
+}
+
+func (mp *memoryPipeline) Del(keys ...string) {
+	mp.ops = append(mp.ops, func() { mp.cache.del(keys...) })
+}
+
+func (mp *memoryPipeline) SAdd(key string, members ...interface{}) {
+	mp.ops = append(mp.ops, func() { mp.cache.sadd(key, members...) })
+}
+
+func (mp *memoryPipeline) SRem(key string, members ...interface{}) {
+	mp.ops = append(mp.ops, func() { mp.cache.srem(key, members...) })
+}
+
+func (mp *memoryPipeline) ZAdd(key string, members ...CacheZ) {
+	mp.ops = append(mp.ops, func() { mp.cache.zadd(key, members...) })
+}
+
+func (mp *memoryPipeline) ZRem(key string, members ...interface{}) {
+	mp.ops = append(mp.ops, func() { mp.cache.zrem(key, members...) })
+}
+
+func (mp *memoryPipeline) ZRangeStoreByScore(dst, src string, min, max float64) {
+	mp.ops = append(mp.ops, func() { mp.cache.zrangestore(dst, src, min, max) })
+}
+
+func (mp *memoryPipeline) ZUnionStore(dst string, keys ...string) {
+	mp.ops = append(mp.ops, func() { mp.cache.zunionstore(dst, keys...) })
+}
+
+func (mp *memoryPipeline) Expire(key string, ttl time.Duration) {
+	mp.ops = append(mp.ops, func() {
+		time.AfterFunc(ttl, func() {
+			mp.cache.mu.Lock()
+			defer mp.cache.mu.Unlock()
+			mp.cache.del(key)
+		})
+	})
+}
+
+func (mp *memoryPipeline) Exec(ctx context.Context) error {
+	mp.cache.mu.Lock()
+	defer mp.cache.mu.Unlock()
+	for _, op := range mp.ops {
+		op()
+	}
+	mp.ops = nil
+	return nil
+}
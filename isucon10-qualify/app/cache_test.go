@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/cast"
+)
+
+func TestCancelChairReservation(t *testing.T) {
+	chairs := testChairs(6, 50)
+	last, more := chairs[0], chairs[1]
+	last.Stock, last.Price = 1, 0
+	more.Stock = 2
+	loadTestTables(t, chairs, nil)
+
+	ctx := context.Background()
+	inStock := func(id int64) bool {
+		members, err := cache.SMembers(ctx, cacheKey("chair", "instock"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range members {
+			if m == cast.ToString(id) {
+				return true
+			}
+		}
+		return false
+	}
+	lowPriced := func(id int64) bool {
+		for _, v := range lowPricedChairs.IDs() {
+			if v == id {
+				return true
+			}
+		}
+		return false
+	}
+	stock := func(id int64) string {
+		v, err := cache.Get(ctx, cacheKey("chair", "stock", cast.ToString(id)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	r, err := reserveChair(last.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Left != 0 || r.Row == "" {
+		t.Fatalf("reservation = %+v, want the last one with its row", r)
+	}
+	if _, ok := chairIndex.byID[last.ID]; ok || inStock(last.ID) || lowPriced(last.ID) {
+		t.Fatalf("sold out chair %d still searchable", last.ID)
+	}
+
+	if err := cancelChairReservation(r); err != nil {
+		t.Fatal(err)
+	}
+	if stock(last.ID) != "1" {
+		t.Errorf("stock = %s, want 1", stock(last.ID))
+	}
+	if v, err := cache.Get(ctx, CacheKeyChairID+cast.ToString(last.ID)); err != nil || v != r.Row {
+		t.Errorf("detail = %q, %v, want the reserved row", v, err)
+	}
+	if _, ok := chairIndex.byID[last.ID]; !ok || !inStock(last.ID) || !lowPriced(last.ID) {
+		t.Errorf("cancelled chair %d not searchable", last.ID)
+	}
+	if _, err := reserveChair(last.ID); err != nil {
+		t.Errorf("reserve after cancel: %v", err)
+	}
+
+	r, err = reserveChair(more.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Left != 1 || r.Row != "" {
+		t.Fatalf("reservation = %+v, want one left without a row", r)
+	}
+	if err := cancelChairReservation(r); err != nil {
+		t.Fatal(err)
+	}
+	if stock(more.ID) != "2" {
+		t.Errorf("stock = %s, want 2", stock(more.ID))
+	}
+}
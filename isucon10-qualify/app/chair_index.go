@@ -0,0 +1,239 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+var chairIndex = NewChairIndex()
+
+type chairEntry struct {
+	chair    *Chair
+	price    uint64
+	height   uint64
+	width    uint64
+	depth    uint64
+	features bitset
+}
+
+func newChairEntry(chair *Chair) *chairEntry {
+	return &chairEntry{
+		chair:    chair,
+		price:    rangeMask(chairSearchCondition.Price, chair.Price),
+		height:   rangeMask(chairSearchCondition.Height, chair.Height),
+		width:    rangeMask(chairSearchCondition.Width, chair.Width),
+		depth:    rangeMask(chairSearchCondition.Depth, chair.Depth),
+		features: featureBits(chairSearchCondition.Feature.List, chair.Features),
+	}
+}
+
+// ChairQuery in-memory form of the searchChairs conditions
+type ChairQuery struct {
+	// range masks, 0 matches any
+	Price  uint64
+	Height uint64
+	Width  uint64
+	Depth  uint64
+
+	Kind     listFilter
+	Color    listFilter
+	Features featureFilter
+	// Text nil matches any
+	Text *TextMatch
+
+	// Sort nil is defaultChairSort
+	Sort *chairSort
+	// After when set pages start after the cursor instead of at offset 0
+	After *searchCursor
+	// Facets when set every match is counted into it
+	Facets *ChairFacets
+}
+
+func (q *ChairQuery) sort() *chairSort {
+	if q.Sort == nil {
+		return defaultChairSort
+	}
+	return q.Sort
+}
+
+func (q *ChairQuery) match(e *chairEntry) bool {
+	c := e.chair
+	return matchMask(e.price, q.Price) &&
+		matchMask(e.height, q.Height) &&
+		matchMask(e.width, q.Width) &&
+		matchMask(e.depth, q.Depth) &&
+		q.Text.match(c.ID) &&
+		q.Kind.match(c.Kind) &&
+		q.Color.match(c.Color) &&
+		q.Features.match(e.features)
+}
+
+// ChairIndex holds every chair in stock, once in every chairSorts order
+type ChairIndex struct {
+	mu     sync.RWMutex
+	orders map[*chairSort][]*chairEntry
+	byID   map[int64]*chairEntry
+}
+
+func NewChairIndex() *ChairIndex {
+	return &ChairIndex{
+		orders: make(map[*chairSort][]*chairEntry),
+		byID:   make(map[int64]*chairEntry),
+	}
+}
+
+// Load replaces the whole index
+func (ci *ChairIndex) Load(chairs []*Chair) {
+	entries := make([]*chairEntry, 0, len(chairs))
+	byID := make(map[int64]*chairEntry, len(chairs))
+	for _, chair := range chairs {
+		if chair.Stock <= 0 {
+			continue
+		}
+		e := newChairEntry(chair)
+		entries = append(entries, e)
+		byID[chair.ID] = e
+	}
+	orders := make(map[*chairSort][]*chairEntry, len(chairSorts))
+	for _, s := range chairSorts {
+		sorted := make([]*chairEntry, len(entries))
+		copy(sorted, entries)
+		sort.Slice(sorted, func(i, j int) bool {
+			return s.less(sorted[i].chair, sorted[j].chair)
+		})
+		orders[s] = sorted
+	}
+
+	ci.mu.Lock()
+	ci.orders = orders
+	ci.byID = byID
+	ci.mu.Unlock()
+}
+
+// Orders the chair ids of every order by sort name, see indexSnapshot
+func (ci *ChairIndex) Orders() map[string][]int64 {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+
+	orders := make(map[string][]int64, len(ci.orders))
+	for s, entries := range ci.orders {
+		ids := make([]int64, 0, len(entries))
+		for _, e := range entries {
+			ids = append(ids, e.chair.ID)
+		}
+		orders[s.name] = ids
+	}
+	return orders
+}
+
+// LoadOrders Load in the orders from Orders instead of sorting, they must
+// hold exactly the chairs in stock
+func (ci *ChairIndex) LoadOrders(chairs []*Chair, ids map[string][]int64) error {
+	byID := make(map[int64]*chairEntry, len(chairs))
+	for _, chair := range chairs {
+		if chair.Stock > 0 {
+			byID[chair.ID] = newChairEntry(chair)
+		}
+	}
+	orders := make(map[*chairSort][]*chairEntry, len(chairSorts))
+	for _, s := range chairSorts {
+		if len(ids[s.name]) != len(byID) {
+			return errIndexSnapshotMismatch
+		}
+		sorted := make([]*chairEntry, 0, len(byID))
+		for _, id := range ids[s.name] {
+			e, ok := byID[id]
+			if !ok {
+				return errIndexSnapshotMismatch
+			}
+			sorted = append(sorted, e)
+		}
+		orders[s] = sorted
+	}
+
+	ci.mu.Lock()
+	ci.orders = orders
+	ci.byID = byID
+	ci.mu.Unlock()
+	return nil
+}
+
+// Add inserts or replaces chairs, chairs out of stock are dropped
+func (ci *ChairIndex) Add(chairs ...*Chair) {
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+
+	for _, chair := range chairs {
+		ci.remove(chair.ID)
+		if chair.Stock <= 0 {
+			continue
+		}
+		e := newChairEntry(chair)
+		for _, s := range chairSorts {
+			entries := ci.orders[s]
+			i := sort.Search(len(entries), func(i int) bool {
+				return s.less(chair, entries[i].chair)
+			})
+			entries = append(entries, nil)
+			copy(entries[i+1:], entries[i:])
+			entries[i] = e
+			ci.orders[s] = entries
+		}
+		ci.byID[chair.ID] = e
+	}
+}
+
+// Remove drops a chair, e.g. when it is sold out
+func (ci *ChairIndex) Remove(id int64) {
+	ci.mu.Lock()
+	ci.remove(id)
+	ci.mu.Unlock()
+}
+
+func (ci *ChairIndex) remove(id int64) {
+	e, ok := ci.byID[id]
+	if !ok {
+		return
+	}
+	delete(ci.byID, id)
+	for _, s := range chairSorts {
+		entries := ci.orders[s]
+		i := sort.Search(len(entries), func(i int) bool {
+			return !s.less(entries[i].chair, e.chair)
+		})
+		if i < len(entries) && entries[i] == e {
+			ci.orders[s] = append(entries[:i], entries[i+1:]...)
+		}
+	}
+}
+
+// Search returns the total count of matching chairs and the page
+// [offset, offset+limit) of the ones after q.After, in q.Sort order
+func (ci *ChairIndex) Search(q *ChairQuery, offset, limit int) (int64, []Chair) {
+	ci.mu.RLock()
+	defer ci.mu.RUnlock()
+
+	s := q.sort()
+	var count int64
+	chairs := []Chair{}
+	for _, e := range ci.orders[s] {
+		if !q.match(e) {
+			continue
+		}
+		count++
+		if q.Facets != nil {
+			q.Facets.add(e)
+		}
+		if !q.After.passed(s.key(e.chair), e.chair.ID) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if len(chairs) < limit {
+			chairs = append(chairs, *e.chair)
+		}
+	}
+	return count, chairs
+}
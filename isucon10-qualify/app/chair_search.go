@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/spf13/cast"
+)
+
+// chair search backends, picked with CHAIR_SEARCH
+const (
+	chairSearchIndex = "index"
+	chairSearchCache = "cache"
+	chairSearchSQL   = "sql"
+)
+
+var chairSearcher ChairSearcher
+
+// ChairSearcher answers searchChairs, the count of every match and the page
+// [offset, offset+limit) of the matches after q.After, in q.Sort order
+type ChairSearcher interface {
+	Search(q *ChairQuery, offset, limit int) (int64, []Chair, error)
+}
+
+func initChairSearcher() {
+	backend := getEnv("CHAIR_SEARCH", chairSearchIndex)
+	switch backend {
+	case chairSearchIndex:
+		chairSearcher = indexChairSearcher{}
+	case chairSearchCache:
+		chairSearcher = cacheChairSearcher{}
+	case chairSearchSQL:
+		chairSearcher = sqlChairSearcher{}
+	default:
+		logger.Fatalf("unknown CHAIR_SEARCH: %s", backend)
+	}
+	logger.Infof("chair search backend: %s", backend)
+}
+
+// indexChairSearcher the in-memory ChairIndex
+type indexChairSearcher struct{}
+
+func (indexChairSearcher) Search(q *ChairQuery, offset, limit int) (int64, []Chair, error) {
+	count, chairs := chairIndex.Search(q, offset, limit)
+	return count, chairs, nil
+}
+
+// cacheChairSearcher intersects the sets and sorted sets cacheChair keeps
+type cacheChairSearcher struct{}
+
+// chairSearchTTL drops the temporary sets a crashed search leaves behind
+const chairSearchTTL = time.Minute
+
+var (
+	// chairSearchPrefix names the temporary sets of the servers sharing the
+	// cache apart, chairSearchSeq those of concurrent searches
+	chairSearchPrefix = cacheKey("chair", "search", hostname(), strconv.Itoa(os.Getpid()))
+	chairSearchSeq    int64
+)
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "localhost"
+	}
+	return name
+}
+
+func (cacheChairSearcher) Search(q *ChairQuery, offset, limit int) (int64, []Chair, error) {
+	ctx := context.Background()
+	tmpPrefix := cacheKey(chairSearchPrefix, cast.ToString(atomic.AddInt64(&chairSearchSeq, 1)))
+
+	// the temporary sets are built on the server in one round trip
+	keys := []string{cacheKey("chair", "instock")}
+	tmpKeys := make([]string, 0)
+	pipe := cache.Pipeline()
+	temporary := func(name string) string {
+		tmp := cacheKey(tmpPrefix, name)
+		tmpKeys = append(tmpKeys, tmp)
+		pipe.Expire(tmp, chairSearchTTL)
+		return tmp
+	}
+	defer func() {
+		if len(tmpKeys) > 0 {
+			cache.Del(ctx, tmpKeys...)
+		}
+	}()
+
+	ranges := []struct {
+		name string
+		cond RangeCondition
+		mask uint64
+	}{
+		{"price", chairSearchCondition.Price, q.Price},
+		{"height", chairSearchCondition.Height, q.Height},
+		{"width", chairSearchCondition.Width, q.Width},
+		{"depth", chairSearchCondition.Depth, q.Depth},
+	}
+	for _, r := range ranges {
+		if r.mask == 0 {
+			continue
+		}
+		parts := make([]string, 0)
+		for i, rg := range r.cond.Ranges {
+			if r.mask&rg.bit() == 0 {
+				continue
+			}
+			min, max := math.Inf(-1), math.Inf(1)
+			if rg.Min != -1 {
+				min = float64(rg.Min)
+			}
+			if rg.Max != -1 {
+				// scores are integers, so < max is <= max-1
+				max = float64(rg.Max - 1)
+			}
+			part := temporary(r.name + strconv.Itoa(i))
+			pipe.ZRangeStoreByScore(part, cacheKey("chair", r.name), min, max)
+			parts = append(parts, part)
+		}
+		if len(parts) == 1 {
+			keys = append(keys, parts[0])
+			continue
+		}
+		tmp := temporary(r.name)
+		pipe.ZUnionStore(tmp, parts...)
+		keys = append(keys, tmp)
+	}
+	lists := []struct {
+		name   string
+		values listFilter
+	}{
+		{"kind", q.Kind},
+		{"color", q.Color},
+	}
+	for _, l := range lists {
+		switch len(l.values) {
+		case 0:
+			continue
+		case 1:
+			keys = append(keys, cacheKey("chair", l.name, l.values[0]))
+			continue
+		}
+		sets := make([]string, 0, len(l.values))
+		for _, v := range l.values {
+			sets = append(sets, cacheKey("chair", l.name, v))
+		}
+		tmp := temporary(l.name)
+		pipe.ZUnionStore(tmp, sets...)
+		keys = append(keys, tmp)
+	}
+	for _, f := range q.Features.known(chairSearchCondition.Feature.List) {
+		keys = append(keys, cacheKey("chair", "feature", f))
+	}
+	if len(tmpKeys) > 0 {
+		if err := pipe.Exec(ctx); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	ids, err := cache.ZInter(ctx, keys...)
+	if err != nil {
+		return 0, nil, err
+	}
+	if q.Text != nil {
+		matched := ids[:0]
+		for _, id := range ids {
+			if q.Text.match(cast.ToInt64(id)) {
+				matched = append(matched, id)
+			}
+		}
+		ids = matched
+	}
+	count := int64(len(ids))
+
+	// facets and orders other than popularity need the rows. A row missing
+	// since ZInter, i.e. sold out, stays counted and is left out before
+	// paging so it takes no place on a page.
+	order := q.sort()
+	var rows map[string]*Chair
+	if q.Facets != nil || order != defaultChairSort {
+		rows, err = fetchCacheChairs(ctx, ids)
+		if err != nil {
+			return 0, nil, err
+		}
+		found := ids[:0]
+		for _, id := range ids {
+			if row, ok := rows[id]; ok {
+				found = append(found, id)
+				if q.Facets != nil {
+					q.Facets.add(newChairEntry(row))
+				}
+			}
+		}
+		ids = found
+	}
+
+	popularity, err := cache.MGet(ctx, prefixKeys(cacheKey("chair", "popularity", ""), ids)...)
+	if err != nil {
+		return 0, nil, err
+	}
+	// cached rows leave out popularity, the popularity order and the cursor need it
+	sortKeys := make(map[string]int64, len(ids))
+	for _, id := range ids {
+		row, ok := rows[id]
+		if !ok {
+			row = &Chair{ID: cast.ToInt64(id)}
+		}
+		row.Popularity = cast.ToInt64(popularity[cacheKey("chair", "popularity", id)])
+		sortKeys[id] = order.key(row)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if sortKeys[ids[i]] != sortKeys[ids[j]] {
+			return sortKeys[ids[i]] < sortKeys[ids[j]]
+		}
+		return cast.ToInt64(ids[i]) < cast.ToInt64(ids[j])
+	})
+
+	if q.After != nil {
+		ids = ids[sort.Search(len(ids), func(i int) bool {
+			return q.After.passed(sortKeys[ids[i]], cast.ToInt64(ids[i]))
+		}):]
+	}
+	if offset < 0 || offset >= len(ids) {
+		return count, []Chair{}, nil
+	}
+
+	// without the rows the page is fetched in rounds, one more for every
+	// row gone since ZInter
+	rest := ids[offset:]
+	chairs := make([]Chair, 0, limit)
+	for len(rest) > 0 && len(chairs) < limit {
+		batch := rest
+		if n := limit - len(chairs); len(batch) > n {
+			batch = batch[:n]
+		}
+		rest = rest[len(batch):]
+		fetched := rows
+		if fetched == nil {
+			if fetched, err = fetchCacheChairs(ctx, batch); err != nil {
+				return 0, nil, err
+			}
+		}
+		for _, id := range batch {
+			if row, ok := fetched[id]; ok {
+				row.Popularity = cast.ToInt64(popularity[cacheKey("chair", "popularity", id)])
+				chairs = append(chairs, *row)
+			}
+		}
+	}
+	return count, chairs, nil
+}
+
+func fetchCacheChairs(ctx context.Context, ids []string) (map[string]*Chair, error) {
+	vals, err := cache.MGet(ctx, prefixKeys(CacheKeyChairID, ids)...)
+	if err != nil {
+		return nil, err
+	}
+	rows := make(map[string]*Chair, len(vals))
+	for _, id := range ids {
+		val, ok := vals[CacheKeyChairID+id]
+		if !ok {
+			continue
+		}
+		row := &Chair{}
+		if err := jsoniter.UnmarshalFromString(val, row); err != nil {
+			return nil, err
+		}
+		rows[id] = row
+	}
+	return rows, nil
+}
+
+func prefixKeys(prefix string, ids []string) []string {
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, prefix+id)
+	}
+	return keys
+}
+
+// sqlChairSearcher the original query against MySQL
+type sqlChairSearcher struct{}
+
+func (sqlChairSearcher) Search(q *ChairQuery, offset, limit int) (int64, []Chair, error) {
+	conditions := make([]string, 0)
+	params := make([]interface{}, 0)
+
+	ranges := []struct {
+		column string
+		cond   RangeCondition
+		mask   uint64
+	}{
+		{"price", chairSearchCondition.Price, q.Price},
+		{"height", chairSearchCondition.Height, q.Height},
+		{"width", chairSearchCondition.Width, q.Width},
+		{"depth", chairSearchCondition.Depth, q.Depth},
+	}
+	for _, r := range ranges {
+		if cond, args := rangeConditionSQL(r.column, r.cond, r.mask); cond != "" {
+			conditions = append(conditions, cond)
+			params = append(params, args...)
+		}
+	}
+	if cond, args := listConditionSQL("kind", q.Kind); cond != "" {
+		conditions = append(conditions, cond)
+		params = append(params, args...)
+	}
+	if cond, args := listConditionSQL("color", q.Color); cond != "" {
+		conditions = append(conditions, cond)
+		params = append(params, args...)
+	}
+	for _, f := range q.Features.known(chairSearchCondition.Feature.List) {
+		conditions = append(conditions, "features LIKE CONCAT('%', ?, '%')")
+		params = append(params, f)
+	}
+	if cond, args := textConditionSQL(q.Text); cond != "" {
+		conditions = append(conditions, cond)
+		params = append(params, args...)
+	}
+	conditions = append(conditions, "stock > 0")
+
+	searchCondition := strings.Join(conditions, " AND ")
+
+	var count int64
+	if q.Facets != nil {
+		matched := []*Chair{}
+		if err := db.Select(&matched, "SELECT "+chairSelectColumns+" FROM chair WHERE "+searchCondition, params...); err != nil {
+			return 0, nil, err
+		}
+		for _, row := range matched {
+			q.Facets.add(newChairEntry(row))
+		}
+		count = int64(len(matched))
+	} else if err := db.Get(&count, "SELECT COUNT(*) FROM chair WHERE "+searchCondition, params...); err != nil {
+		return 0, nil, err
+	}
+
+	order := q.sort()
+	if q.After != nil {
+		searchCondition += " AND (" + order.keySQL + " > ? OR (" + order.keySQL + " = ? AND id > ?))"
+		params = append(params, q.After.Key, q.After.Key, q.After.ID)
+	}
+
+	chairs := []Chair{}
+	params = append(params, limit, offset)
+	if err := db.Select(&chairs, "SELECT "+chairSelectColumns+" FROM chair WHERE "+searchCondition+" ORDER BY "+order.keySQL+", id LIMIT ? OFFSET ?", params...); err != nil {
+		return 0, nil, err
+	}
+	return count, chairs, nil
+}
+
+// rangeConditionSQL ORs the ranges of the mask, "" when it matches anything
+func rangeConditionSQL(column string, cond RangeCondition, mask uint64) (string, []interface{}) {
+	if mask == 0 {
+		return "", nil
+	}
+	ors := make([]string, 0)
+	params := make([]interface{}, 0)
+	for _, r := range cond.Ranges {
+		if mask&r.bit() == 0 {
+			continue
+		}
+		if !r.bounded() {
+			return "", nil
+		}
+		ands := make([]string, 0, 2)
+		if r.Min != -1 {
+			ands = append(ands, column+" >= ?")
+			params = append(params, r.Min)
+		}
+		if r.Max != -1 {
+			ands = append(ands, column+" < ?")
+			params = append(params, r.Max)
+		}
+		ors = append(ors, strings.Join(ands, " AND "))
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", params
+}
+
+// listConditionSQL an IN over the values, "" when it matches anything
+func listConditionSQL(column string, values listFilter) (string, []interface{}) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	params := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		params = append(params, v)
+	}
+	return column + " IN (?" + strings.Repeat(", ?", len(values)-1) + ")", params
+}
+
+// textConditionSQL an IN over the ids the text index matched, "" when it
+// matches anything
+func textConditionSQL(m *TextMatch) (string, []interface{}) {
+	if m == nil {
+		return "", nil
+	}
+	ids := m.IDs()
+	if len(ids) == 0 {
+		return "FALSE", nil
+	}
+	params := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		params = append(params, id)
+	}
+	return "id IN (?" + strings.Repeat(", ?", len(ids)-1) + ")", params
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/cast"
+)
+
+// TestCacheChairSearcherMissingRow a row gone between ZInter and the row
+// fetch, e.g. sold out, takes no place on the page
+func TestCacheChairSearcherMissingRow(t *testing.T) {
+	chairs := testChairs(7, 200)
+	loadTestTables(t, chairs, nil)
+
+	for _, name := range []string{"popularity", "price_asc", "size_asc"} {
+		t.Run(name, func(t *testing.T) {
+			order, err := getChairSort(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q := &ChairQuery{Sort: order}
+			count, want := chairIndex.Search(q, 0, 6)
+			if len(want) != 6 {
+				t.Fatalf("got %d chairs, want 6", len(want))
+			}
+
+			// the detail of the first row is gone, chair:instock still has it
+			gone := want[0].ID
+			key := CacheKeyChairID + cast.ToString(gone)
+			row, err := cache.Get(context.Background(), key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cache.Del(context.Background(), key); err != nil {
+				t.Fatal(err)
+			}
+			defer cache.Set(context.Background(), key, row)
+
+			_, next := chairIndex.Search(q, 0, 7)
+			gotCount, got, err := cacheChairSearcher{}.Search(q, 0, 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotCount != count {
+				t.Errorf("count = %d, want %d with the missing row", gotCount, count)
+			}
+			if fmt.Sprint(chairIDs(got)) != fmt.Sprint(chairIDs(next[1:6])) {
+				t.Errorf("ids = %v, want %v", chairIDs(got), chairIDs(next[1:6]))
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// cluster grid, every map tile of a zoom level is split into
+// clusterCellsPerTile^2 cells. A bbox spanning more than maxClusterCells
+// cells is clustered at a lower zoom.
+const (
+	maxClusterZoom      = 22
+	clusterCellsPerTile = 4
+	clusterSample       = 5
+	maxClusterCells     = 4096
+)
+
+type EstateCluster struct {
+	ID        string  `json:"id"`
+	Count     int64   `json:"count"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	// EstateIDs the most popular estates of the cluster, popularity_desc, id
+	EstateIDs []int64 `json:"estateIds"`
+}
+
+// clusterCellSize the cell side in degrees at zoom
+func clusterCellSize(zoom int) float64 {
+	return 360 / math.Exp2(float64(zoom)) / clusterCellsPerTile
+}
+
+// clusterZoom the highest zoom up to zoom whose grid over b has at most
+// maxClusterCells cells
+func clusterZoom(b BoundingBox, zoom int) int {
+	for ; zoom > 0; zoom-- {
+		size := clusterCellSize(zoom)
+		lat := math.Floor(b.BottomRightCorner.Latitude/size) - math.Floor(b.TopLeftCorner.Latitude/size) + 1
+		lng := math.Floor(b.BottomRightCorner.Longitude/size) - math.Floor(b.TopLeftCorner.Longitude/size) + 1
+		if lat*lng <= maxClusterCells {
+			break
+		}
+	}
+	return zoom
+}
+
+// clusterEstates groups the estates inside b into the grid cells of zoom,
+// every cluster at the centroid of its estates, the largest clusters first
+func clusterEstates(b BoundingBox, zoom int) []EstateCluster {
+	size := clusterCellSize(zoom)
+	type cell struct {
+		lat int64
+		lng int64
+	}
+	type sum struct {
+		cluster   *EstateCluster
+		latitude  float64
+		longitude float64
+	}
+	sums := make(map[cell]*sum)
+
+	// Within visits in popularity_desc, id order so the first estates of a
+	// cluster are its sample
+	estateGeoIndex.Within(b, func(e *Estate) bool {
+		k := cell{
+			lat: int64(math.Floor(e.Latitude / size)),
+			lng: int64(math.Floor(e.Longitude / size)),
+		}
+		s, ok := sums[k]
+		if !ok {
+			s = &sum{cluster: &EstateCluster{
+				ID:        strconv.Itoa(zoom) + "/" + strconv.FormatInt(k.lat, 10) + "/" + strconv.FormatInt(k.lng, 10),
+				EstateIDs: make([]int64, 0, clusterSample),
+			}}
+			sums[k] = s
+		}
+		s.cluster.Count++
+		s.latitude += e.Latitude
+		s.longitude += e.Longitude
+		if len(s.cluster.EstateIDs) < clusterSample {
+			s.cluster.EstateIDs = append(s.cluster.EstateIDs, e.ID)
+		}
+		return true
+	})
+
+	clusters := make([]EstateCluster, 0, len(sums))
+	for _, s := range sums {
+		s.cluster.Latitude = s.latitude / float64(s.cluster.Count)
+		s.cluster.Longitude = s.longitude / float64(s.cluster.Count)
+		clusters = append(clusters, *s.cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].Count != clusters[j].Count {
+			return clusters[i].Count > clusters[j].Count
+		}
+		return clusters[i].ID < clusters[j].ID
+	})
+	return clusters
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestClusterZoom(t *testing.T) {
+	cells := func(b BoundingBox, zoom int) float64 {
+		size := clusterCellSize(zoom)
+		lat := math.Floor(b.BottomRightCorner.Latitude/size) - math.Floor(b.TopLeftCorner.Latitude/size) + 1
+		lng := math.Floor(b.BottomRightCorner.Longitude/size) - math.Floor(b.TopLeftCorner.Longitude/size) + 1
+		return lat * lng
+	}
+	bbox := func(minLat, minLng, maxLat, maxLng float64) BoundingBox {
+		return BoundingBox{
+			TopLeftCorner:     Coordinate{Latitude: minLat, Longitude: minLng},
+			BottomRightCorner: Coordinate{Latitude: maxLat, Longitude: maxLng},
+		}
+	}
+	tests := []struct {
+		name string
+		b    BoundingBox
+		zoom int
+		want int
+	}{
+		{"small bbox keeps zoom", bbox(35.68, 139.76, 35.69, 139.77), 14, 14},
+		{"point at max zoom", bbox(35.68, 139.76, 35.68, 139.76), maxClusterZoom, maxClusterZoom},
+		{"tokyo at max zoom", bbox(35, 139, 36, 140), maxClusterZoom, -1},
+		{"world at max zoom", bbox(-90, -180, 90, 180), maxClusterZoom, -1},
+		{"zoom 0", bbox(-90, -180, 90, 180), 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := clusterZoom(tt.b, tt.zoom)
+			if tt.want >= 0 && got != tt.want {
+				t.Fatalf("clusterZoom = %d, want %d", got, tt.want)
+			}
+			if got > tt.zoom {
+				t.Fatalf("clusterZoom = %d above %d", got, tt.zoom)
+			}
+			if got > 0 && cells(tt.b, got) > maxClusterCells {
+				t.Errorf("zoom %d has %g cells", got, cells(tt.b, got))
+			}
+			// the highest zoom that fits
+			if got < tt.zoom && cells(tt.b, got+1) <= maxClusterCells {
+				t.Errorf("zoom %d fits, got %d", got+1, got)
+			}
+		})
+	}
+}
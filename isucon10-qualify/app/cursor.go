@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// searchCursor the sort key and id of the last row of a search page, the
+// next page starts right after it in that sort order
+type searchCursor struct {
+	Sort string
+	Key  int64
+	ID   int64
+}
+
+func newSearchCursor(sort string, key, id int64) *searchCursor {
+	return &searchCursor{Sort: sort, Key: key, ID: id}
+}
+
+// parseSearchCursor reads the cursor query parameter
+func parseSearchCursor(s string) (*searchCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	parts := strings.Split(string(b), ",")
+	if len(parts) != 3 {
+		return nil, errInvalidCursor
+	}
+	key, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	return &searchCursor{Sort: parts[0], Key: key, ID: id}, nil
+}
+
+func (sc *searchCursor) String() string {
+	s := sc.Sort + "," + strconv.FormatInt(sc.Key, 10) + "," + strconv.FormatInt(sc.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// passed reports whether a row comes after the cursor, a nil cursor passes everything
+func (sc *searchCursor) passed(key, id int64) bool {
+	if sc == nil {
+		return true
+	}
+	if key != sc.Key {
+		return key > sc.Key
+	}
+	return id > sc.ID
+}
+
+// searchPage the paging parameters of a search
+type searchPage struct {
+	Offset  int
+	PerPage int
+	// Limit the rows to fetch, one more than PerPage with a cursor so the
+	// extra row tells whether there is a next page
+	Limit int
+	After *searchCursor
+	// Facets whether the matches are counted into facets
+	Facets bool
+}
+
+// parseSearchPage reads page, perPage, cursor and facets. A cursor
+// parameter opts in to cursor paging and replaces page, the page starts
+// after the cursor or at the first row when it is empty.
+func parseSearchPage(c *fiber.Ctx, sort string) (searchPage, error) {
+	var p searchPage
+	page := 0
+	paginate := c.Context().QueryArgs().Has("cursor")
+	if paginate {
+		if c.Query("cursor") != "" {
+			cursor, err := parseSearchCursor(c.Query("cursor"))
+			if err == nil && cursor.Sort != sort {
+				err = errInvalidCursor
+			}
+			if err != nil {
+				return p, invalid("cursor", "%s", err)
+			}
+			p.After = cursor
+		}
+	} else {
+		var err error
+		page, err = validateInt("page", c.Query("page"), 0, maxPage)
+		if err != nil {
+			return p, err
+		}
+	}
+
+	perPage, err := validateInt("perPage", c.Query("perPage"), minPerPage, maxPerPage)
+	if err != nil {
+		return p, err
+	}
+	p.Offset, p.PerPage, p.Limit = page*perPage, perPage, perPage
+	if paginate {
+		p.Limit++
+	}
+
+	p.Facets = c.Query("facets") == "true"
+	return p, nil
+}
+
+// more reports whether the rows fetched go past the page, the page is then
+// cut to PerPage and its last row makes the next cursor
+func (p searchPage) more(rows int) bool {
+	return rows > p.PerPage
+}
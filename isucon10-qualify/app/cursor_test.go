@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestSearchCursorRoundTrip(t *testing.T) {
+	tests := []*searchCursor{
+		newSearchCursor("popularity", -42, 7),
+		newSearchCursor("price_asc", 0, 1),
+		newSearchCursor("newest", -9223372036854775807, 9223372036854775807),
+	}
+	for _, want := range tests {
+		got, err := parseSearchCursor(want.String())
+		if err != nil {
+			t.Fatalf("parseSearchCursor(%q): %v", want.String(), err)
+		}
+		if *got != *want {
+			t.Errorf("parseSearchCursor(%q) = %+v, want %+v", want.String(), got, want)
+		}
+	}
+}
+
+func TestParseSearchCursorInvalid(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "!!!"},
+		{"padded", base64.URLEncoding.EncodeToString([]byte("popularity,1,2"))},
+		{"two parts", encode("popularity,1")},
+		{"four parts", encode("popularity,1,2,3")},
+		{"key", encode("popularity,x,2")},
+		{"id", encode("popularity,1,")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseSearchCursor(tt.cursor); err != errInvalidCursor {
+				t.Errorf("parseSearchCursor(%q) err = %v, want %v", tt.cursor, err, errInvalidCursor)
+			}
+		})
+	}
+}
+
+func TestSearchCursorPassed(t *testing.T) {
+	c := newSearchCursor("price_asc", 100, 5)
+	tests := []struct {
+		key  int64
+		id   int64
+		want bool
+	}{
+		{99, 9, false},
+		{100, 4, false},
+		{100, 5, false},
+		{100, 6, true},
+		{101, 1, true},
+	}
+	for _, tt := range tests {
+		if got := c.passed(tt.key, tt.id); got != tt.want {
+			t.Errorf("passed(%d, %d) = %v, want %v", tt.key, tt.id, got, tt.want)
+		}
+	}
+	var none *searchCursor
+	if !none.passed(0, 0) {
+		t.Error("a nil cursor must pass everything")
+	}
+}
+
+func TestSearchChairsCursorPaging(t *testing.T) {
+	chairs := testChairs(3, 60)
+	loadTestTables(t, chairs, nil)
+	chairSearcher = indexChairSearcher{}
+	app := fiber.New(fiber.Config{ErrorHandler: errorHandler})
+	app.Get("/api/chair/search", searchChairs)
+
+	search := func(query string) ChairSearchResponse {
+		t.Helper()
+		res, err := app.Test(httptest.NewRequest("GET", "/api/chair/search?"+query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d", query, res.StatusCode)
+		}
+		var body ChairSearchResponse
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	const filter = "kind=ゲーミングチェア,座椅子"
+	all := search(filter + "&page=0&perPage=100")
+	if all.NextCursor != "" {
+		t.Errorf("page paging answered nextCursor %q", all.NextCursor)
+	}
+	if all.Count <= 5 || all.Count >= 100 {
+		t.Fatalf("count %d does not span several pages", all.Count)
+	}
+
+	ids := make([]int64, 0)
+	cursor := ""
+	for i := 0; ; i++ {
+		res := search(filter + "&perPage=5&cursor=" + cursor)
+		// a full last page must not point at an empty one
+		if len(res.Chairs) == 0 {
+			t.Fatalf("page %d is empty", i)
+		}
+		if res.Count != all.Count {
+			t.Errorf("page %d: count = %d, want %d", i, res.Count, all.Count)
+		}
+		ids = append(ids, chairIDs(res.Chairs)...)
+		if res.NextCursor == "" {
+			break
+		}
+		cursor = res.NextCursor
+	}
+	if fmt.Sprint(ids) != fmt.Sprint(chairIDs(all.Chairs)) {
+		t.Errorf("cursor pages = %v, want %v", ids, chairIDs(all.Chairs))
+	}
+}
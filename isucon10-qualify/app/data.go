@@ -40,8 +40,10 @@ type Chair struct {
 }
 
 type ChairSearchResponse struct {
-	Count  int64   `json:"count"`
-	Chairs []Chair `json:"chairs"`
+	Count      int64        `json:"count"`
+	Chairs     []Chair      `json:"chairs"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+	Facets     *ChairFacets `json:"facets,omitempty"`
 }
 
 type ChairListResponse struct {
@@ -67,8 +69,22 @@ type Estate struct {
 
 //EstateSearchResponse estate/searchへのレスポンスの形式
 type EstateSearchResponse struct {
-	Count   int64    `json:"count"`
-	Estates []Estate `json:"estates"`
+	Count      int64         `json:"count"`
+	Estates    []Estate      `json:"estates"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+	Facets     *EstateFacets `json:"facets,omitempty"`
+}
+
+//EstateNearbyResponse estate/nearbyへのレスポンスの形式
+type EstateNearbyResponse struct {
+	Count   int64            `json:"count"`
+	Estates []EstateDistance `json:"estates"`
+}
+
+// EstateClusterResponse estate/clustersへのレスポンスの形式
+type EstateClusterResponse struct {
+	Zoom     int             `json:"zoom"`
+	Clusters []EstateCluster `json:"clusters"`
 }
 
 type EstateListResponse struct {
@@ -214,7 +230,8 @@ func (mc *MySQLConnectionEnv) ConnectDB() (*sqlx.DB, error) {
 	return sqlx.Open("mysql", dsn)
 }
 
-func init() {
+// loadSearchConditions reads the fixture conditions, tests set their own
+func loadSearchConditions() {
 	jsonText, err := ioutil.ReadFile("../fixture/chair_condition.json")
 	if err != nil {
 		fmt.Printf("%v\n", err)
@@ -229,3 +246,48 @@ func init() {
 	}
 	json.Unmarshal(jsonText, &estateSearchCondition)
 }
+
+func parseChairRecord(record []string) (*Chair, error) {
+	rm := RecordMapper{Record: record}
+	chair := &Chair{
+		ID:          int64(rm.NextInt()),
+		Name:        rm.NextString(),
+		Description: rm.NextString(),
+		Thumbnail:   rm.NextString(),
+		Price:       int64(rm.NextInt()),
+		Height:      int64(rm.NextInt()),
+		Width:       int64(rm.NextInt()),
+		Depth:       int64(rm.NextInt()),
+		Color:       rm.NextString(),
+		Features:    rm.NextString(),
+		Kind:        rm.NextString(),
+		Popularity:  int64(rm.NextInt()),
+		Stock:       int64(rm.NextInt()),
+	}
+	if err := rm.Err(); err != nil {
+		return nil, err
+	}
+	return chair, nil
+}
+
+func parseEstateRecord(record []string) (*Estate, error) {
+	rm := RecordMapper{Record: record}
+	estate := &Estate{
+		ID:          int64(rm.NextInt()),
+		Name:        rm.NextString(),
+		Description: rm.NextString(),
+		Thumbnail:   rm.NextString(),
+		Address:     rm.NextString(),
+		Latitude:    rm.NextFloat(),
+		Longitude:   rm.NextFloat(),
+		Rent:        int64(rm.NextInt()),
+		DoorHeight:  int64(rm.NextInt()),
+		DoorWidth:   int64(rm.NextInt()),
+		Features:    rm.NextString(),
+		Popularity:  int64(rm.NextInt()),
+	}
+	if err := rm.Err(); err != nil {
+		return nil, err
+	}
+	return estate, nil
+}
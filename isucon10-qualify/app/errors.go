@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// APIError an error answered as JSON by errorHandler
+type APIError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"error"`
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	if e.Field != "" {
+		return e.Code + ": " + e.Field + ": " + e.Message
+	}
+	return e.Code + ": " + e.Message
+}
+
+func newAPIError(status int, code, format string, args ...interface{}) error {
+	return &APIError{
+		Status:  status,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// errInternal the 500 answer, the handler logs the cause
+var errInternal = newAPIError(http.StatusInternalServerError, "internal_error", "internal server error")
+
+// errorHandler renders every error returned by a handler as an APIError,
+// fiber errors (e.g. unknown routes) keep their status
+func errorHandler(c *fiber.Ctx, err error) error {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) {
+			apiErr = &APIError{
+				Status:  fiberErr.Code,
+				Code:    strings.ToLower(strings.ReplaceAll(http.StatusText(fiberErr.Code), " ", "_")),
+				Message: fiberErr.Message,
+			}
+		} else {
+			logger.Errorf("%s %s : %v", c.Method(), c.Path(), err)
+			apiErr = errInternal.(*APIError)
+		}
+	}
+	if apiErr.Status < http.StatusInternalServerError {
+		logger.Infof("%s %s : %v", c.Method(), c.Path(), apiErr)
+	}
+	return c.Status(apiErr.Status).JSON(apiErr)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+var estateIndex = NewEstateIndex()
+
+type estateEntry struct {
+	estate     *Estate
+	rent       uint64
+	doorHeight uint64
+	doorWidth  uint64
+	features   bitset
+}
+
+func newEstateEntry(estate *Estate) *estateEntry {
+	return &estateEntry{
+		estate:     estate,
+		rent:       rangeMask(estateSearchCondition.Rent, estate.Rent),
+		doorHeight: rangeMask(estateSearchCondition.DoorHeight, estate.DoorHeight),
+		doorWidth:  rangeMask(estateSearchCondition.DoorWidth, estate.DoorWidth),
+		features:   featureBits(estateSearchCondition.Feature.List, estate.Features),
+	}
+}
+
+// estateLess orders estates by popularity_desc, id
+func estateLess(a, b *Estate) bool {
+	if a.Popularity != b.Popularity {
+		return a.Popularity > b.Popularity
+	}
+	return a.ID < b.ID
+}
+
+// EstateQuery in-memory form of the searchEstates conditions
+type EstateQuery struct {
+	// range masks, 0 matches any
+	Rent       uint64
+	DoorHeight uint64
+	DoorWidth  uint64
+
+	Features featureFilter
+	// Text nil matches any
+	Text *TextMatch
+
+	// Sort nil is defaultEstateSort
+	Sort *estateSort
+	// After when set pages start after the cursor instead of at offset 0
+	After *searchCursor
+	// Facets when set every match is counted into it
+	Facets *EstateFacets
+}
+
+func (q *EstateQuery) sort() *estateSort {
+	if q.Sort == nil {
+		return defaultEstateSort
+	}
+	return q.Sort
+}
+
+func (q *EstateQuery) match(e *estateEntry) bool {
+	return q.Text.match(e.estate.ID) &&
+		matchMask(e.rent, q.Rent) &&
+		matchMask(e.doorHeight, q.DoorHeight) &&
+		matchMask(e.doorWidth, q.DoorWidth) &&
+		q.Features.match(e.features)
+}
+
+// matchEstate match for estates outside the index, e.g. from estateGeoIndex,
+// with the entry estateIndex keeps for the estate
+func (q *EstateQuery) matchEstate(estate *Estate) bool {
+	e, ok := estateIndex.get(estate.ID)
+	return ok && q.match(e)
+}
+
+// EstateIndex holds every estate, once in every estateSorts order
+type EstateIndex struct {
+	mu     sync.RWMutex
+	orders map[*estateSort][]*estateEntry
+	byID   map[int64]*estateEntry
+}
+
+func NewEstateIndex() *EstateIndex {
+	return &EstateIndex{
+		orders: make(map[*estateSort][]*estateEntry),
+		byID:   make(map[int64]*estateEntry),
+	}
+}
+
+// Load replaces the whole index
+func (ei *EstateIndex) Load(estates []*Estate) {
+	entries := make([]*estateEntry, 0, len(estates))
+	byID := make(map[int64]*estateEntry, len(estates))
+	for _, estate := range estates {
+		e := newEstateEntry(estate)
+		entries = append(entries, e)
+		byID[estate.ID] = e
+	}
+	orders := make(map[*estateSort][]*estateEntry, len(estateSorts))
+	for _, s := range estateSorts {
+		sorted := make([]*estateEntry, len(entries))
+		copy(sorted, entries)
+		sort.Slice(sorted, func(i, j int) bool {
+			return s.less(sorted[i].estate, sorted[j].estate)
+		})
+		orders[s] = sorted
+	}
+
+	ei.mu.Lock()
+	ei.orders = orders
+	ei.byID = byID
+	ei.mu.Unlock()
+}
+
+// Orders the estate ids of every order by sort name, see indexSnapshot
+func (ei *EstateIndex) Orders() map[string][]int64 {
+	ei.mu.RLock()
+	defer ei.mu.RUnlock()
+
+	orders := make(map[string][]int64, len(ei.orders))
+	for s, entries := range ei.orders {
+		ids := make([]int64, 0, len(entries))
+		for _, e := range entries {
+			ids = append(ids, e.estate.ID)
+		}
+		orders[s.name] = ids
+	}
+	return orders
+}
+
+// LoadOrders Load in the orders from Orders instead of sorting, they must
+// hold exactly the estates
+func (ei *EstateIndex) LoadOrders(estates []*Estate, ids map[string][]int64) error {
+	byID := make(map[int64]*estateEntry, len(estates))
+	for _, estate := range estates {
+		byID[estate.ID] = newEstateEntry(estate)
+	}
+	orders := make(map[*estateSort][]*estateEntry, len(estateSorts))
+	for _, s := range estateSorts {
+		if len(ids[s.name]) != len(byID) {
+			return errIndexSnapshotMismatch
+		}
+		sorted := make([]*estateEntry, 0, len(byID))
+		for _, id := range ids[s.name] {
+			e, ok := byID[id]
+			if !ok {
+				return errIndexSnapshotMismatch
+			}
+			sorted = append(sorted, e)
+		}
+		orders[s] = sorted
+	}
+
+	ei.mu.Lock()
+	ei.orders = orders
+	ei.byID = byID
+	ei.mu.Unlock()
+	return nil
+}
+
+func (ei *EstateIndex) get(id int64) (*estateEntry, bool) {
+	ei.mu.RLock()
+	defer ei.mu.RUnlock()
+	e, ok := ei.byID[id]
+	return e, ok
+}
+
+// Sorted every estate in popularity_desc, id order
+func (ei *EstateIndex) Sorted() []*Estate {
+	ei.mu.RLock()
+	defer ei.mu.RUnlock()
+
+	entries := ei.orders[defaultEstateSort]
+	estates := make([]*Estate, 0, len(entries))
+	for _, e := range entries {
+		estates = append(estates, e.estate)
+	}
+	return estates
+}
+
+// Add inserts or replaces estates
+func (ei *EstateIndex) Add(estates ...*Estate) {
+	ei.mu.Lock()
+	defer ei.mu.Unlock()
+
+	for _, estate := range estates {
+		ei.remove(estate.ID)
+		e := newEstateEntry(estate)
+		for _, s := range estateSorts {
+			entries := ei.orders[s]
+			i := sort.Search(len(entries), func(i int) bool {
+				return s.less(estate, entries[i].estate)
+			})
+			entries = append(entries, nil)
+			copy(entries[i+1:], entries[i:])
+			entries[i] = e
+			ei.orders[s] = entries
+		}
+		ei.byID[estate.ID] = e
+	}
+}
+
+func (ei *EstateIndex) remove(id int64) {
+	e, ok := ei.byID[id]
+	if !ok {
+		return
+	}
+	delete(ei.byID, id)
+	for _, s := range estateSorts {
+		entries := ei.orders[s]
+		i := sort.Search(len(entries), func(i int) bool {
+			return !s.less(entries[i].estate, e.estate)
+		})
+		if i < len(entries) && entries[i] == e {
+			ei.orders[s] = append(entries[:i], entries[i+1:]...)
+		}
+	}
+}
+
+// Search returns the total count of matching estates and the page
+// [offset, offset+limit) of the ones after q.After, in q.Sort order
+func (ei *EstateIndex) Search(q *EstateQuery, offset, limit int) (int64, []Estate) {
+	ei.mu.RLock()
+	defer ei.mu.RUnlock()
+
+	s := q.sort()
+	var count int64
+	estates := []Estate{}
+	for _, e := range ei.orders[s] {
+		if !q.match(e) {
+			continue
+		}
+		count++
+		if q.Facets != nil {
+			q.Facets.add(e)
+		}
+		if !q.After.passed(s.key(e.estate), e.estate.ID) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if len(estates) < limit {
+			estates = append(estates, *e.estate)
+		}
+	}
+	return count, estates
+}
@@ -0,0 +1,108 @@
+package main
+
+// RangeFacet the number of matches within a range of a RangeCondition
+type RangeFacet struct {
+	ID    int64 `json:"id"`
+	Count int64 `json:"count"`
+}
+
+// ListFacet the number of matches with a value of a ListCondition
+type ListFacet struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+func newRangeFacets(cond RangeCondition) []RangeFacet {
+	facets := make([]RangeFacet, 0, len(cond.Ranges))
+	for _, r := range cond.Ranges {
+		facets = append(facets, RangeFacet{ID: r.ID})
+	}
+	return facets
+}
+
+func newListFacets(cond ListCondition) []ListFacet {
+	facets := make([]ListFacet, 0, len(cond.List))
+	for _, v := range cond.List {
+		facets = append(facets, ListFacet{Value: v})
+	}
+	return facets
+}
+
+func countRange(facets []RangeFacet, cond RangeCondition, mask uint64) {
+	for i, r := range cond.Ranges {
+		if mask&r.bit() != 0 {
+			facets[i].Count++
+		}
+	}
+}
+
+func countList(facets []ListFacet, cond ListCondition, v string) {
+	if i := indexOf(cond.List, v); i >= 0 {
+		facets[i].Count++
+	}
+}
+
+func countFeatures(facets []ListFacet, bits bitset) {
+	for i := range facets {
+		if bits.has(i) {
+			facets[i].Count++
+		}
+	}
+}
+
+// ChairFacets per value counts of the chairs matching a search
+type ChairFacets struct {
+	Price   []RangeFacet `json:"price"`
+	Height  []RangeFacet `json:"height"`
+	Width   []RangeFacet `json:"width"`
+	Depth   []RangeFacet `json:"depth"`
+	Color   []ListFacet  `json:"color"`
+	Kind    []ListFacet  `json:"kind"`
+	Feature []ListFacet  `json:"feature"`
+}
+
+func newChairFacets() *ChairFacets {
+	return &ChairFacets{
+		Price:   newRangeFacets(chairSearchCondition.Price),
+		Height:  newRangeFacets(chairSearchCondition.Height),
+		Width:   newRangeFacets(chairSearchCondition.Width),
+		Depth:   newRangeFacets(chairSearchCondition.Depth),
+		Color:   newListFacets(chairSearchCondition.Color),
+		Kind:    newListFacets(chairSearchCondition.Kind),
+		Feature: newListFacets(chairSearchCondition.Feature),
+	}
+}
+
+func (f *ChairFacets) add(e *chairEntry) {
+	countRange(f.Price, chairSearchCondition.Price, e.price)
+	countRange(f.Height, chairSearchCondition.Height, e.height)
+	countRange(f.Width, chairSearchCondition.Width, e.width)
+	countRange(f.Depth, chairSearchCondition.Depth, e.depth)
+	countList(f.Color, chairSearchCondition.Color, e.chair.Color)
+	countList(f.Kind, chairSearchCondition.Kind, e.chair.Kind)
+	countFeatures(f.Feature, e.features)
+}
+
+// EstateFacets per value counts of the estates matching a search
+type EstateFacets struct {
+	DoorWidth  []RangeFacet `json:"doorWidth"`
+	DoorHeight []RangeFacet `json:"doorHeight"`
+	Rent       []RangeFacet `json:"rent"`
+	Feature    []ListFacet  `json:"feature"`
+}
+
+func newEstateFacets() *EstateFacets {
+	return &EstateFacets{
+		DoorWidth:  newRangeFacets(estateSearchCondition.DoorWidth),
+		DoorHeight: newRangeFacets(estateSearchCondition.DoorHeight),
+		Rent:       newRangeFacets(estateSearchCondition.Rent),
+		Feature:    newListFacets(estateSearchCondition.Feature),
+	}
+}
+
+func (f *EstateFacets) add(e *estateEntry) {
+	countRange(f.DoorWidth, estateSearchCondition.DoorWidth, e.doorWidth)
+	countRange(f.DoorHeight, estateSearchCondition.DoorHeight, e.doorHeight)
+	countRange(f.Rent, estateSearchCondition.Rent, e.rent)
+	countFeatures(f.Feature, e.features)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+var (
+	estateFitIndex = NewFitIndex()
+	chairFitKeys   = NewChairFitKeys()
+)
+
+// fitEntry door sides of an estate, lo <= hi
+type fitEntry struct {
+	lo     int64
+	hi     int64
+	estate *Estate
+}
+
+func newFitEntry(estate *Estate) fitEntry {
+	lo, hi := estate.DoorWidth, estate.DoorHeight
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	return fitEntry{lo: lo, hi: hi, estate: estate}
+}
+
+// fitKey the two smallest sides of a chair, a <= b
+type fitKey struct {
+	a int64
+	b int64
+}
+
+// chairFitKey a chair passes a door in some orientation iff its two smallest
+// sides fit the door's two sides, so the six door_width/door_height
+// combinations collapse into lo >= a AND hi >= b.
+func chairFitKey(chair *Chair) fitKey {
+	dims := []int64{chair.Width, chair.Height, chair.Depth}
+	sort.Slice(dims, func(i, j int) bool { return dims[i] < dims[j] })
+	return fitKey{a: dims[0], b: dims[1]}
+}
+
+// ChairFitKeys the fitKey of every chair, sold out ones included, the
+// recommended_estate of a sold out chair is still answered
+type ChairFitKeys struct {
+	mu   sync.RWMutex
+	keys map[int64]fitKey
+}
+
+func NewChairFitKeys() *ChairFitKeys {
+	return &ChairFitKeys{
+		keys: make(map[int64]fitKey),
+	}
+}
+
+// Load replaces every key
+func (ck *ChairFitKeys) Load(chairs []*Chair) {
+	keys := make(map[int64]fitKey, len(chairs))
+	for _, chair := range chairs {
+		keys[chair.ID] = chairFitKey(chair)
+	}
+
+	ck.mu.Lock()
+	ck.keys = keys
+	ck.mu.Unlock()
+}
+
+// Add inserts or replaces chairs
+func (ck *ChairFitKeys) Add(chairs ...*Chair) {
+	ck.mu.Lock()
+	defer ck.mu.Unlock()
+
+	for _, chair := range chairs {
+		ck.keys[chair.ID] = chairFitKey(chair)
+	}
+}
+
+func (ck *ChairFitKeys) Get(id int64) (fitKey, bool) {
+	ck.mu.RLock()
+	defer ck.mu.RUnlock()
+
+	key, ok := ck.keys[id]
+	return key, ok
+}
+
+// FitIndex answers recommended_estate, estates ordered by popularity_desc, id
+// with the results memoized per chair size until the estates change
+type FitIndex struct {
+	mu      sync.RWMutex
+	entries []fitEntry
+	memo    map[fitKey][]Estate
+}
+
+func NewFitIndex() *FitIndex {
+	return &FitIndex{
+		memo: make(map[fitKey][]Estate),
+	}
+}
+
+// Load replaces the whole index
+func (fi *FitIndex) Load(estates []*Estate) {
+	sorted := make([]*Estate, len(estates))
+	copy(sorted, estates)
+	sort.Slice(sorted, func(i, j int) bool {
+		return estateLess(sorted[i], sorted[j])
+	})
+	fi.LoadSorted(sorted)
+}
+
+// LoadSorted Load for estates already in popularity_desc, id order
+func (fi *FitIndex) LoadSorted(estates []*Estate) {
+	entries := make([]fitEntry, 0, len(estates))
+	for _, estate := range estates {
+		entries = append(entries, newFitEntry(estate))
+	}
+
+	fi.mu.Lock()
+	fi.entries = entries
+	fi.memo = make(map[fitKey][]Estate)
+	fi.mu.Unlock()
+}
+
+// Add inserts or replaces estates
+func (fi *FitIndex) Add(estates ...*Estate) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	for _, estate := range estates {
+		for i, e := range fi.entries {
+			if e.estate.ID == estate.ID {
+				fi.entries = append(fi.entries[:i], fi.entries[i+1:]...)
+				break
+			}
+		}
+		i := sort.Search(len(fi.entries), func(i int) bool {
+			return estateLess(estate, fi.entries[i].estate)
+		})
+		fi.entries = append(fi.entries, fitEntry{})
+		copy(fi.entries[i+1:], fi.entries[i:])
+		fi.entries[i] = newFitEntry(estate)
+	}
+	fi.memo = make(map[fitKey][]Estate)
+}
+
+// Fit returns the top Limit estates a chair of key can pass through
+func (fi *FitIndex) Fit(key fitKey) []Estate {
+	fi.mu.RLock()
+	estates, ok := fi.memo[key]
+	fi.mu.RUnlock()
+	if ok {
+		return estates
+	}
+
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	if estates, ok := fi.memo[key]; ok {
+		return estates
+	}
+	estates = make([]Estate, 0, Limit)
+	for _, e := range fi.entries {
+		if len(estates) >= Limit {
+			break
+		}
+		if e.lo >= key.a && e.hi >= key.b {
+			estates = append(estates, *e.estate)
+		}
+	}
+	fi.memo[key] = estates
+	return estates
+}
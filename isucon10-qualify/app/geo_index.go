@@ -0,0 +1,299 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/kellydunn/golang-geo"
+)
+
+// geoCellSize grid cell size in degrees
+const geoCellSize = 0.05
+
+var estateGeoIndex = NewGeoIndex()
+
+type geoCell struct {
+	lat int32
+	lng int32
+}
+
+func geoCellOf(latitude, longitude float64) geoCell {
+	return geoCell{
+		lat: int32(math.Floor(latitude / geoCellSize)),
+		lng: int32(math.Floor(longitude / geoCellSize)),
+	}
+}
+
+// GeoIndex grid index over estate latitude/longitude, every cell is ordered
+// by popularity_desc, id
+type GeoIndex struct {
+	mu    sync.RWMutex
+	cells map[geoCell][]*Estate
+	byID  map[int64]*Estate
+}
+
+func NewGeoIndex() *GeoIndex {
+	return &GeoIndex{
+		cells: make(map[geoCell][]*Estate),
+		byID:  make(map[int64]*Estate),
+	}
+}
+
+// Load replaces the whole index
+func (gi *GeoIndex) Load(estates []*Estate) {
+	sorted := make([]*Estate, len(estates))
+	copy(sorted, estates)
+	sort.Slice(sorted, func(i, j int) bool {
+		return estateLess(sorted[i], sorted[j])
+	})
+	gi.LoadSorted(sorted)
+}
+
+// LoadSorted Load for estates already in popularity_desc, id order
+func (gi *GeoIndex) LoadSorted(estates []*Estate) {
+	cells := make(map[geoCell][]*Estate)
+	byID := make(map[int64]*Estate, len(estates))
+	for _, estate := range estates {
+		cell := geoCellOf(estate.Latitude, estate.Longitude)
+		cells[cell] = append(cells[cell], estate)
+		byID[estate.ID] = estate
+	}
+
+	gi.mu.Lock()
+	gi.cells = cells
+	gi.byID = byID
+	gi.mu.Unlock()
+}
+
+// Add inserts or replaces estates
+func (gi *GeoIndex) Add(estates ...*Estate) {
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	for _, estate := range estates {
+		gi.remove(estate.ID)
+		cell := geoCellOf(estate.Latitude, estate.Longitude)
+		list := gi.cells[cell]
+		i := sort.Search(len(list), func(i int) bool {
+			return estateLess(estate, list[i])
+		})
+		list = append(list, nil)
+		copy(list[i+1:], list[i:])
+		list[i] = estate
+		gi.cells[cell] = list
+		gi.byID[estate.ID] = estate
+	}
+}
+
+func (gi *GeoIndex) remove(id int64) {
+	old, ok := gi.byID[id]
+	if !ok {
+		return
+	}
+	delete(gi.byID, id)
+	cell := geoCellOf(old.Latitude, old.Longitude)
+	list := gi.cells[cell]
+	for i, e := range list {
+		if e == old {
+			gi.cells[cell] = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+}
+
+// Within calls f for every estate inside b (bounds inclusive) in
+// popularity_desc, id order, until f returns false
+func (gi *GeoIndex) Within(b BoundingBox, f func(*Estate) bool) {
+	gi.mu.RLock()
+	defer gi.mu.RUnlock()
+
+	minCell := geoCellOf(b.TopLeftCorner.Latitude, b.TopLeftCorner.Longitude)
+	maxCell := geoCellOf(b.BottomRightCorner.Latitude, b.BottomRightCorner.Longitude)
+
+	h := &geoCursorHeap{}
+	push := func(list []*Estate) {
+		if len(list) > 0 {
+			*h = append(*h, list)
+		}
+	}
+	area := (int64(maxCell.lat) - int64(minCell.lat) + 1) * (int64(maxCell.lng) - int64(minCell.lng) + 1)
+	if area > int64(len(gi.cells)) {
+		for cell, list := range gi.cells {
+			if cell.lat >= minCell.lat && cell.lat <= maxCell.lat && cell.lng >= minCell.lng && cell.lng <= maxCell.lng {
+				push(list)
+			}
+		}
+	} else {
+		for lat := minCell.lat; lat <= maxCell.lat; lat++ {
+			for lng := minCell.lng; lng <= maxCell.lng; lng++ {
+				push(gi.cells[geoCell{lat: lat, lng: lng}])
+			}
+		}
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		list := (*h)[0]
+		e := list[0]
+		if len(list) > 1 {
+			(*h)[0] = list[1:]
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+
+		if e.Latitude < b.TopLeftCorner.Latitude || e.Latitude > b.BottomRightCorner.Latitude ||
+			e.Longitude < b.TopLeftCorner.Longitude || e.Longitude > b.BottomRightCorner.Longitude {
+			continue
+		}
+		if !f(e) {
+			return
+		}
+	}
+}
+
+// geoCellKm the north-south size of a cell, east-west it shrinks by cos(latitude)
+const geoCellKm = geoCellSize * math.Pi / 180 * 6371
+
+// EstateDistance an estate and its great-circle distance in km from the
+// point of a Nearest search
+type EstateDistance struct {
+	Estate
+	Distance float64 `json:"distance"`
+}
+
+// Nearest the k estates matching f closest to (latitude, longitude), within
+// radiusKm unless it is 0, ordered by distance, id. The cells are visited in
+// rings around the point until no unvisited cell can hold a closer estate.
+// count is every match within radiusKm, or the k found without a radius.
+func (gi *GeoIndex) Nearest(latitude, longitude, radiusKm float64, k int, f func(*Estate) bool) (int64, []EstateDistance) {
+	gi.mu.RLock()
+	defer gi.mu.RUnlock()
+
+	origin := geo.NewPoint(latitude, longitude)
+	center := geoCellOf(latitude, longitude)
+	h := &nearestHeap{}
+	var count int64
+	visit := func(list []*Estate) {
+		for _, e := range list {
+			d := origin.GreatCircleDistance(geo.NewPoint(e.Latitude, e.Longitude))
+			if radiusKm > 0 {
+				// counted even when not among the k closest
+				if d > radiusKm || !f(e) {
+					continue
+				}
+				count++
+			}
+			if h.Len() == k && !nearestLess(d, e.ID, (*h)[0].Distance, (*h)[0].ID) {
+				continue
+			}
+			if radiusKm == 0 && !f(e) {
+				continue
+			}
+			heap.Push(h, EstateDistance{Estate: *e, Distance: d})
+			if h.Len() > k {
+				heap.Pop(h)
+			}
+		}
+	}
+
+	for r := int32(0); ; r++ {
+		// the point lies anywhere in its cell, so every estate outside the
+		// rings so far is at least r-1 cells, bound km, away
+		bound := float64(r-1) * geoCellKm
+		if far := math.Abs(latitude) + float64(r)*geoCellSize; far < 90 {
+			bound *= math.Cos(far * math.Pi / 180)
+		} else {
+			bound = 0
+		}
+		if r > 0 && (radiusKm > 0 && bound > radiusKm || radiusKm == 0 && h.Len() == k && bound > (*h)[0].Distance) {
+			break
+		}
+
+		// rings larger than the index, visit the remaining cells at once
+		side := 2*int64(r) + 1
+		if side*side > int64(len(gi.cells)) {
+			for cell, list := range gi.cells {
+				if abs32(cell.lat-center.lat) >= r || abs32(cell.lng-center.lng) >= r {
+					visit(list)
+				}
+			}
+			break
+		}
+
+		for lat := center.lat - r; lat <= center.lat+r; lat++ {
+			step := int32(1)
+			if lat != center.lat-r && lat != center.lat+r {
+				step = 2 * r
+			}
+			for lng := center.lng - r; lng <= center.lng+r; lng += step {
+				visit(gi.cells[geoCell{lat: lat, lng: lng}])
+			}
+		}
+	}
+
+	estates := make([]EstateDistance, h.Len())
+	for i := len(estates) - 1; i >= 0; i-- {
+		estates[i] = heap.Pop(h).(EstateDistance)
+	}
+	if radiusKm == 0 {
+		count = int64(len(estates))
+	}
+	return count, estates
+}
+
+func abs32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func nearestLess(da float64, ida int64, db float64, idb int64) bool {
+	if da != db {
+		return da < db
+	}
+	return ida < idb
+}
+
+// nearestHeap max-heap of the closest estates so far, the farthest on top
+type nearestHeap []EstateDistance
+
+func (h nearestHeap) Len() int { return len(h) }
+func (h nearestHeap) Less(i, j int) bool {
+	return nearestLess(h[j].Distance, h[j].ID, h[i].Distance, h[i].ID)
+}
+func (h nearestHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *nearestHeap) Push(x interface{}) {
+	*h = append(*h, x.(EstateDistance))
+}
+
+func (h *nearestHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// geoCursorHeap merges the sorted cells, the head of every cell is its next estate
+type geoCursorHeap [][]*Estate
+
+func (h geoCursorHeap) Len() int           { return len(h) }
+func (h geoCursorHeap) Less(i, j int) bool { return estateLess(h[i][0], h[j][0]) }
+func (h geoCursorHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *geoCursorHeap) Push(x interface{}) {
+	*h = append(*h, x.([]*Estate))
+}
+
+func (h *geoCursorHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
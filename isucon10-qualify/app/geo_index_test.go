@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/kellydunn/golang-geo"
+)
+
+// bruteNearest Nearest over every estate
+func bruteNearest(estates []*Estate, latitude, longitude, radiusKm float64, k int, f func(*Estate) bool) (int64, []EstateDistance) {
+	origin := geo.NewPoint(latitude, longitude)
+	all := make([]EstateDistance, 0)
+	for _, e := range estates {
+		d := origin.GreatCircleDistance(geo.NewPoint(e.Latitude, e.Longitude))
+		if radiusKm > 0 && d > radiusKm || !f(e) {
+			continue
+		}
+		all = append(all, EstateDistance{Estate: *e, Distance: d})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return nearestLess(all[i].Distance, all[i].ID, all[j].Distance, all[j].ID)
+	})
+	count := int64(len(all))
+	if len(all) > k {
+		all = all[:k]
+	}
+	if radiusKm == 0 {
+		count = int64(len(all))
+	}
+	return count, all
+}
+
+func distanceIDs(estates []EstateDistance) []int64 {
+	ids := make([]int64, 0, len(estates))
+	for _, e := range estates {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestGeoIndexNearest(t *testing.T) {
+	estates := testEstates(4, 2000)
+	// two estates at the same point order by id
+	twin := *estates[0]
+	twin.ID = int64(len(estates) + 1)
+	estates = append(estates, &twin)
+	gi := NewGeoIndex()
+	gi.Load(estates)
+
+	every := func(*Estate) bool { return true }
+	even := func(e *Estate) bool { return e.ID%2 == 0 }
+	tests := []struct {
+		name     string
+		lat, lng float64
+		radiusKm float64
+		k        int
+		f        func(*Estate) bool
+	}{
+		{"k inside", 35.5, 139.5, 0, 10, every},
+		{"k filtered", 35.5, 139.5, 0, 10, even},
+		{"k on a twin", estates[0].Latitude, estates[0].Longitude, 0, 2, every},
+		{"k outside the index", 40, 145, 0, 5, every},
+		{"k above every estate", 35.5, 139.5, 0, len(estates) + 10, every},
+		{"radius", 35.2, 139.8, 5, 20, every},
+		{"radius above k", 35.5, 139.5, 30, 5, even},
+		{"radius empty", 10, 10, 100, 5, every},
+		{"south", -35.5, 139.5, 0, 3, every},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantCount, want := bruteNearest(estates, tt.lat, tt.lng, tt.radiusKm, tt.k, tt.f)
+			count, got := gi.Nearest(tt.lat, tt.lng, tt.radiusKm, tt.k, tt.f)
+			if count != wantCount {
+				t.Errorf("count = %d, want %d", count, wantCount)
+			}
+			if fmt.Sprint(distanceIDs(got)) != fmt.Sprint(distanceIDs(want)) {
+				t.Errorf("ids = %v, want %v", distanceIDs(got), distanceIDs(want))
+			}
+		})
+	}
+}
+
+// TestGeoIndexNearestPrunes the rings stop once the k closest are found, far
+// cells are never visited
+func TestGeoIndexNearestPrunes(t *testing.T) {
+	estates := testEstates(5, 2000)
+	gi := NewGeoIndex()
+	gi.Load(estates)
+
+	checked := 0
+	_, got := gi.Nearest(35.5, 139.5, 0, 5, func(*Estate) bool {
+		checked++
+		return true
+	})
+	if len(got) != 5 {
+		t.Fatalf("got %d estates, want 5", len(got))
+	}
+	if checked > len(estates)/10 {
+		t.Errorf("checked %d of %d estates for k=5", checked, len(estates))
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kellydunn/golang-geo"
+)
+
+const mimeGeoJSON = "application/geo+json"
+
+// NazottePolygon an outer ring and the holes cut out of it
+type NazottePolygon struct {
+	Outer Coordinates   `json:"outer"`
+	Holes []Coordinates `json:"holes,omitempty"`
+}
+
+// NazotteArea the polygons of a nazotte request, an estate matches when it is
+// inside any outer ring and outside that polygon's holes
+type NazotteArea struct {
+	Polygons []NazottePolygon `json:"polygons"`
+}
+
+func (a NazotteArea) getBoundingBox() BoundingBox {
+	b := a.Polygons[0].Outer.getBoundingBox()
+	for _, p := range a.Polygons[1:] {
+		pb := p.Outer.getBoundingBox()
+		if b.TopLeftCorner.Latitude > pb.TopLeftCorner.Latitude {
+			b.TopLeftCorner.Latitude = pb.TopLeftCorner.Latitude
+		}
+		if b.TopLeftCorner.Longitude > pb.TopLeftCorner.Longitude {
+			b.TopLeftCorner.Longitude = pb.TopLeftCorner.Longitude
+		}
+		if b.BottomRightCorner.Latitude < pb.BottomRightCorner.Latitude {
+			b.BottomRightCorner.Latitude = pb.BottomRightCorner.Latitude
+		}
+		if b.BottomRightCorner.Longitude < pb.BottomRightCorner.Longitude {
+			b.BottomRightCorner.Longitude = pb.BottomRightCorner.Longitude
+		}
+	}
+	return b
+}
+
+// contains builds the rings once and returns the point test
+func (a NazotteArea) contains() func(latitude, longitude float64) bool {
+	type rings struct {
+		outer *geo.Polygon
+		holes []*geo.Polygon
+	}
+	polygons := make([]rings, 0, len(a.Polygons))
+	for _, p := range a.Polygons {
+		r := rings{outer: p.Outer.toPolygon()}
+		for _, h := range p.Holes {
+			r.holes = append(r.holes, h.toPolygon())
+		}
+		polygons = append(polygons, r)
+	}
+
+	return func(latitude, longitude float64) bool {
+		point := geo.NewPoint(latitude, longitude)
+	polygons:
+		for _, p := range polygons {
+			if !p.outer.Contains(point) {
+				continue
+			}
+			for _, h := range p.holes {
+				if h.Contains(point) {
+					continue polygons
+				}
+			}
+			return true
+		}
+		return false
+	}
+}
+
+// nazotteRequest the body of a nazotte search, either the original
+// {"coordinates":[{latitude,longitude}]} or a GeoJSON Polygon, MultiPolygon
+// or a Feature of one
+type nazotteRequest struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *nazotteRequest `json:"geometry"`
+}
+
+// area converts the request, GeoJSON positions are [longitude, latitude]
+func (r *nazotteRequest) area() (NazotteArea, error) {
+	switch r.Type {
+	case "":
+		var cs []Coordinate
+		if err := json.Unmarshal(r.Coordinates, &cs); err != nil {
+			return NazotteArea{}, invalid("coordinates", "must be a list of latitude and longitude")
+		}
+		if len(cs) == 0 {
+			return NazotteArea{}, invalid("coordinates", "is required")
+		}
+		return NazotteArea{Polygons: []NazottePolygon{{Outer: Coordinates{Coordinates: cs}}}}, nil
+	case "Feature":
+		if r.Geometry == nil || r.Geometry.Type == "Feature" {
+			return NazotteArea{}, invalid("geometry", "must be a Polygon or MultiPolygon")
+		}
+		return r.Geometry.area()
+	case "Polygon":
+		var rings [][][]float64
+		if err := json.Unmarshal(r.Coordinates, &rings); err != nil {
+			return NazotteArea{}, invalid("coordinates", "must be a list of linear rings")
+		}
+		p, err := geoJSONPolygon(rings)
+		if err != nil {
+			return NazotteArea{}, err
+		}
+		return NazotteArea{Polygons: []NazottePolygon{p}}, nil
+	case "MultiPolygon":
+		var polygons [][][][]float64
+		if err := json.Unmarshal(r.Coordinates, &polygons); err != nil {
+			return NazotteArea{}, invalid("coordinates", "must be a list of polygons")
+		}
+		if len(polygons) == 0 {
+			return NazotteArea{}, invalid("coordinates", "is required")
+		}
+		a := NazotteArea{Polygons: make([]NazottePolygon, 0, len(polygons))}
+		for _, rings := range polygons {
+			p, err := geoJSONPolygon(rings)
+			if err != nil {
+				return NazotteArea{}, err
+			}
+			a.Polygons = append(a.Polygons, p)
+		}
+		return a, nil
+	}
+	return NazotteArea{}, invalid("type", "unsupported geometry %q", r.Type)
+}
+
+func geoJSONPolygon(rings [][][]float64) (NazottePolygon, error) {
+	if len(rings) == 0 {
+		return NazottePolygon{}, invalid("coordinates", "polygon must have an outer ring")
+	}
+	p := NazottePolygon{}
+	for i, ring := range rings {
+		cs := make([]Coordinate, 0, len(ring))
+		for _, position := range ring {
+			if len(position) < 2 {
+				return NazottePolygon{}, invalid("coordinates", "position must be [longitude, latitude]")
+			}
+			cs = append(cs, Coordinate{Latitude: position[1], Longitude: position[0]})
+		}
+		if len(cs) == 0 {
+			return NazottePolygon{}, invalid("coordinates", "ring must not be empty")
+		}
+		if i == 0 {
+			p.Outer = Coordinates{Coordinates: cs}
+		} else {
+			p.Holes = append(p.Holes, Coordinates{Coordinates: cs})
+		}
+	}
+	return p, nil
+}
+
+// wantsGeoJSON format=geojson or an Accept of application/geo+json, the
+// Estate JSON stays the default and format=json asks for it
+func wantsGeoJSON(c *fiber.Ctx) (bool, error) {
+	switch format := c.Query("format"); format {
+	case "":
+		return strings.Contains(c.Get(fiber.HeaderAccept), mimeGeoJSON), nil
+	case "geojson":
+		return true, nil
+	case "json":
+		return false, nil
+	default:
+		return false, invalid("format", "unknown format %q", format)
+	}
+}
+
+type GeoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type GeoJSONFeature struct {
+	Type       string       `json:"type"`
+	ID         int64        `json:"id"`
+	Geometry   GeoJSONPoint `json:"geometry"`
+	Properties Estate       `json:"properties"`
+}
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// newEstateFeatureCollection the estates as GeoJSON points
+func newEstateFeatureCollection(estates []Estate) GeoJSONFeatureCollection {
+	fc := GeoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]GeoJSONFeature, 0, len(estates)),
+	}
+	for _, e := range estates {
+		fc.Features = append(fc.Features, GeoJSONFeature{
+			Type: "Feature",
+			ID:   e.ID,
+			Geometry: GeoJSONPoint{
+				Type:        "Point",
+				Coordinates: [2]float64{e.Longitude, e.Latitude},
+			},
+			Properties: e,
+		})
+	}
+	return fc
+}
+
+// sendGeoJSON c.JSON with the GeoJSON media type
+func sendGeoJSON(c *fiber.Ctx, v interface{}) error {
+	if err := c.JSON(v); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, mimeGeoJSON)
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import "strings"
+
+// bitset is a set of small integers, used to record which entries of a
+// ListCondition a row matches.
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) set(i int) {
+	b[i/64] |= 1 << (uint(i) % 64)
+}
+
+func (b bitset) has(i int) bool {
+	return b[i/64]&(1<<(uint(i)%64)) != 0
+}
+
+// containsAll reports whether every bit of o is also set in b.
+func (b bitset) containsAll(o bitset) bool {
+	for i := range o {
+		if b[i]&o[i] != o[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// featureBits sets bit i when list[i] is a substring of features, the same
+// test as `features LIKE CONCAT('%', ?, '%')`.
+func featureBits(list []string, features string) bitset {
+	b := newBitset(len(list))
+	for i, f := range list {
+		if strings.Contains(features, f) {
+			b.set(i)
+		}
+	}
+	return b
+}
+
+// featureFilter is the in-memory form of the `features LIKE` conditions,
+// every feature is one of the search condition list and matched by bit
+type featureFilter struct {
+	bits bitset
+}
+
+// newFeatureFilter features must be in list, see validateFeatures
+func newFeatureFilter(list []string, features []string) featureFilter {
+	f := featureFilter{bits: newBitset(len(list))}
+	for _, feature := range features {
+		if i := indexOf(list, feature); i >= 0 {
+			f.bits.set(i)
+		}
+	}
+	return f
+}
+
+func (f featureFilter) match(bits bitset) bool {
+	return bits.containsAll(f.bits)
+}
+
+// known the requested features found in list
+func (f featureFilter) known(list []string) []string {
+	features := make([]string, 0)
+	for i, feature := range list {
+		if i/64 < len(f.bits) && f.bits.has(i) {
+			features = append(features, feature)
+		}
+	}
+	return features
+}
+
+// listFilter the values allowed for a ListCondition column, empty matches any
+type listFilter []string
+
+func (f listFilter) match(v string) bool {
+	return len(f) == 0 || indexOf(f, v) >= 0
+}
+
+func indexOf(list []string, v string) int {
+	for i, s := range list {
+		if s == v {
+			return i
+		}
+	}
+	return -1
+}
+
+// rangeMask returns the bits of every range in cond that contains v, using
+// the same bounds as the SQL it replaces (min <= v < max, -1 is unbounded).
+func rangeMask(cond RangeCondition, v int64) uint64 {
+	var m uint64
+	for _, r := range cond.Ranges {
+		if r.Min != -1 && v < r.Min {
+			continue
+		}
+		if r.Max != -1 && v >= r.Max {
+			continue
+		}
+		m |= r.bit()
+	}
+	return m
+}
+
+func (r *Range) bit() uint64 {
+	return 1 << uint(r.ID)
+}
+
+// bounded reports whether the range restricts anything, i.e. whether the
+// SQL search would have added a condition for it.
+func (r *Range) bounded() bool {
+	return r.Min != -1 || r.Max != -1
+}
+
+// matchMask reports whether a row mask satisfies a query mask, 0 matches any.
+func matchMask(row, query uint64) bool {
+	return query == 0 || row&query != 0
+}
@@ -0,0 +1,75 @@
+package main
+
+import "errors"
+
+var errIndexSnapshotMismatch = errors.New("index snapshot does not match the rows")
+
+// indexSnapshot the in-memory indexes of the initial data by row id, kept
+// next to the table snapshot so initialize reuses the orders and postings
+// instead of sorting and tokenizing every row again
+type indexSnapshot struct {
+	Fingerprint      string
+	ChairOrders      map[string][]int64
+	EstateOrders     map[string][]int64
+	LowPricedChairs  []int64
+	LowPricedEstates []int64
+	ChairText        textIndexData
+	EstateText       textIndexData
+}
+
+// captureIndexes the indexes as buildIndexes left them
+func captureIndexes() *indexSnapshot {
+	return &indexSnapshot{
+		ChairOrders:      chairIndex.Orders(),
+		EstateOrders:     estateIndex.Orders(),
+		LowPricedChairs:  lowPricedChairs.IDs(),
+		LowPricedEstates: lowPricedEstates.IDs(),
+		ChairText:        chairTextIndex.Data(),
+		EstateText:       estateTextIndex.Data(),
+	}
+}
+
+// loadIndexes buildIndexes from a snapshot of the same rows, on an error
+// the indexes are left half loaded and have to be built
+func loadIndexes(chairs []*Chair, estates []*Estate, idx *indexSnapshot) error {
+	if err := chairIndex.LoadOrders(chairs, idx.ChairOrders); err != nil {
+		return err
+	}
+	chairFitKeys.Load(chairs)
+	if err := lowPricedChairs.LoadIDs(chairLowPriced(chairs...), idx.LowPricedChairs); err != nil {
+		return err
+	}
+	if err := chairTextIndex.LoadData(chairTextDocs(chairs...), idx.ChairText); err != nil {
+		return err
+	}
+
+	if err := estateIndex.LoadOrders(estates, idx.EstateOrders); err != nil {
+		return err
+	}
+	sorted := estateIndex.Sorted()
+	estateGeoIndex.LoadSorted(sorted)
+	estateFitIndex.LoadSorted(sorted)
+	if err := lowPricedEstates.LoadIDs(estateLowPriced(estates...), idx.LowPricedEstates); err != nil {
+		return err
+	}
+	if err := estateTextIndex.LoadData(estateTextDocs(estates...), idx.EstateText); err != nil {
+		return err
+	}
+
+	responseCache.Invalidate(responseChair, responseEstate)
+	return nil
+}
+
+// restoreIndexes loads the indexes of the table snapshot, building and
+// saving them the first time
+func restoreIndexes(chairs []*Chair, estates []*Estate) {
+	if idx := tableSnapshot.Index(); idx != nil {
+		err := loadIndexes(chairs, estates, idx)
+		if err == nil {
+			return
+		}
+		logger.Errorf("index snapshot load failed, rebuilding : %v", err)
+	}
+	buildIndexes(chairs, estates)
+	tableSnapshot.SaveIndex(captureIndexes())
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// maxBatchBytes keeps merged INSERTs well under max_allowed_packet
+const maxBatchBytes = 1 << 20
+
+// sqlStatement a statement of a SQL file, first and last are the 1-based
+// statement numbers in the file it covers, they differ for merged INSERTs
+type sqlStatement struct {
+	first int
+	last  int
+	query string
+}
+
+// splitSQL splits a SQL script on `;` outside of quotes and comments.
+// `--` and `#` comments are dropped, /* */ is kept since /*! */ is executable.
+func splitSQL(src string) []sqlStatement {
+	stmts := make([]sqlStatement, 0)
+	var b strings.Builder
+	n := 0
+	flush := func() {
+		q := strings.TrimSpace(b.String())
+		b.Reset()
+		if q == "" {
+			return
+		}
+		n++
+		stmts = append(stmts, sqlStatement{first: n, last: n, query: q})
+	}
+
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`':
+			j := i + 1
+			for j < len(src) && src[j] != ch {
+				if src[j] == '\\' && ch != '`' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				j = len(src) - 1
+			}
+			b.WriteString(src[i : j+1])
+			i = j
+		case ch == '#' || isDashComment(src[i:]):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			b.WriteByte('\n')
+		case ch == '/' && strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				b.WriteString(src[i:])
+				i = len(src)
+				continue
+			}
+			b.WriteString(src[i : i+2+end+2])
+			i += 2 + end + 1
+		case ch == ';':
+			flush()
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	flush()
+	return stmts
+}
+
+// isDashComment `-- ` needs a whitespace after the dashes
+func isDashComment(s string) bool {
+	return strings.HasPrefix(s, "--") && (len(s) == 2 || unicode.IsSpace(rune(s[2])))
+}
+
+// insertValues splits `INSERT INTO t (...) VALUES (...)` into the part up to
+// VALUES and the row list, ok is false for any other statement
+func insertValues(query string) (head string, rows string, ok bool) {
+	if len(query) < 6 || !strings.EqualFold(query[:6], "INSERT") {
+		return "", "", false
+	}
+	upper := strings.ToUpper(query)
+	i := strings.Index(upper, " VALUES")
+	if i < 0 || strings.ContainsAny(query[:i], "'\"") {
+		return "", "", false
+	}
+	head = query[:i+len(" VALUES")]
+	rows = strings.TrimLeftFunc(query[len(head):], unicode.IsSpace)
+	if !strings.HasPrefix(rows, "(") || strings.Contains(strings.ToUpper(rows), "ON DUPLICATE KEY") {
+		return "", "", false
+	}
+	return head, rows, true
+}
+
+// batchInserts merges consecutive INSERTs into the same table and columns
+// into multi-row INSERTs, other statements are left in place
+func batchInserts(stmts []sqlStatement) []sqlStatement {
+	out := make([]sqlStatement, 0, len(stmts))
+	var cur *sqlStatement
+	var curHead string
+	for _, st := range stmts {
+		head, rows, ok := insertValues(st.query)
+		if ok && cur != nil && head == curHead && len(cur.query)+len(rows) < maxBatchBytes {
+			cur.query += "," + rows
+			cur.last = st.last
+			continue
+		}
+		out = append(out, st)
+		cur, curHead = nil, ""
+		if ok {
+			cur, curHead = &out[len(out)-1], head
+		}
+	}
+	return out
+}
+
+// execSQLFile runs a SQL script on a single connection, in order
+func execSQLFile(ctx context.Context, path string) error {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the schema drops the database, which unsets the default database of
+	// that session, so the connection selects it again before going back to
+	// the pool
+	defer conn.ExecContext(ctx, fmt.Sprintf("USE `%s`", mySQLConnectionData.DBName))
+
+	for _, st := range batchInserts(splitSQL(string(src))) {
+		if _, err := conn.ExecContext(ctx, st.query); err != nil {
+			if st.first == st.last {
+				return fmt.Errorf("%s: statement %d: %w", path, st.first, err)
+			}
+			return fmt.Errorf("%s: statements %d-%d: %w", path, st.first, st.last, err)
+		}
+	}
+	return nil
+}
+
+// loadSQLFiles runs the stages one after another, the files of a stage touch
+// different tables and run in parallel
+func loadSQLFiles(ctx context.Context, stages ...[]string) error {
+	for _, files := range stages {
+		var wg sync.WaitGroup
+		errs := make([]error, len(files))
+		for i, path := range files {
+			wg.Add(1)
+			go func(i int, path string) {
+				defer wg.Done()
+				errs[i] = execSQLFile(ctx, path)
+			}(i, path)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
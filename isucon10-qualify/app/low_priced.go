@@ -0,0 +1,213 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+var (
+	lowPricedChairs = NewLowPricedList("chair", func(rows []interface{}) interface{} {
+		chairs := make([]Chair, 0, len(rows))
+		for _, row := range rows {
+			chairs = append(chairs, *row.(*Chair))
+		}
+		return ChairListResponse{Chairs: chairs}
+	})
+	lowPricedEstates = NewLowPricedList("estate", func(rows []interface{}) interface{} {
+		estates := make([]Estate, 0, len(rows))
+		for _, row := range rows {
+			estates = append(estates, *row.(*Estate))
+		}
+		return EstateListResponse{Estates: estates}
+	})
+)
+
+// lowPricedEntry a row ordered by price (rent for estates), id
+type lowPricedEntry struct {
+	price int64
+	id    int64
+	row   interface{}
+}
+
+func (e *lowPricedEntry) less(o *lowPricedEntry) bool {
+	if e.price != o.price {
+		return e.price < o.price
+	}
+	return e.id < o.id
+}
+
+// chairLowPriced the entries of the chairs in stock
+func chairLowPriced(chairs ...*Chair) []lowPricedEntry {
+	entries := make([]lowPricedEntry, 0, len(chairs))
+	for _, chair := range chairs {
+		if chair.Stock <= 0 {
+			continue
+		}
+		entries = append(entries, lowPricedEntry{price: chair.Price, id: chair.ID, row: chair})
+	}
+	return entries
+}
+
+func estateLowPriced(estates ...*Estate) []lowPricedEntry {
+	entries := make([]lowPricedEntry, 0, len(estates))
+	for _, estate := range estates {
+		entries = append(entries, lowPricedEntry{price: estate.Rent, id: estate.ID, row: estate})
+	}
+	return entries
+}
+
+// LowPricedList answers low_priced, every row is kept ordered so a removed
+// one is replaced by the next, and the top Limit are kept encoded
+type LowPricedList struct {
+	name     string
+	response func(rows []interface{}) interface{}
+	mu       sync.RWMutex
+	entries  []lowPricedEntry
+	// byID the entry of every row, to find it in entries by price and id
+	byID map[int64]lowPricedEntry
+	body []byte
+}
+
+// NewLowPricedList response builds the list response from the top rows
+func NewLowPricedList(name string, response func(rows []interface{}) interface{}) *LowPricedList {
+	l := &LowPricedList{name: name, response: response, byID: make(map[int64]lowPricedEntry)}
+	l.encode()
+	return l
+}
+
+// Load replaces the whole list
+func (l *LowPricedList) Load(entries []lowPricedEntry) {
+	sorted := make([]lowPricedEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].less(&sorted[j])
+	})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = sorted
+	l.byID = lowPricedByID(sorted)
+	l.encode()
+}
+
+func lowPricedByID(entries []lowPricedEntry) map[int64]lowPricedEntry {
+	byID := make(map[int64]lowPricedEntry, len(entries))
+	for _, e := range entries {
+		byID[e.id] = e
+	}
+	return byID
+}
+
+// IDs the row ids in list order, see indexSnapshot
+func (l *LowPricedList) IDs() []int64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	ids := make([]int64, 0, len(l.entries))
+	for _, e := range l.entries {
+		ids = append(ids, e.id)
+	}
+	return ids
+}
+
+// LoadIDs Load in the order from IDs instead of sorting, it must hold
+// exactly the entries
+func (l *LowPricedList) LoadIDs(entries []lowPricedEntry, ids []int64) error {
+	if len(ids) != len(entries) {
+		return errIndexSnapshotMismatch
+	}
+	byID := lowPricedByID(entries)
+	sorted := make([]lowPricedEntry, 0, len(entries))
+	for _, id := range ids {
+		e, ok := byID[id]
+		if !ok {
+			return errIndexSnapshotMismatch
+		}
+		sorted = append(sorted, e)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = sorted
+	l.byID = byID
+	l.encode()
+	return nil
+}
+
+// Add inserts or replaces entries
+func (l *LowPricedList) Add(entries ...lowPricedEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	changed := false
+	for _, e := range entries {
+		if i := l.remove(e.id); i >= 0 && i < Limit {
+			changed = true
+		}
+		i := sort.Search(len(l.entries), func(i int) bool {
+			return e.less(&l.entries[i])
+		})
+		l.entries = append(l.entries, lowPricedEntry{})
+		copy(l.entries[i+1:], l.entries[i:])
+		l.entries[i] = e
+		l.byID[e.id] = e
+		if i < Limit {
+			changed = true
+		}
+	}
+	if changed {
+		l.encode()
+	}
+}
+
+// Remove drops the row, e.g. a sold out chair
+func (l *LowPricedList) Remove(id int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if i := l.remove(id); i >= 0 && i < Limit {
+		l.encode()
+	}
+}
+
+// remove returns the position the row had, -1 when absent
+func (l *LowPricedList) remove(id int64) int {
+	e, ok := l.byID[id]
+	if !ok {
+		return -1
+	}
+	delete(l.byID, id)
+	i := sort.Search(len(l.entries), func(i int) bool {
+		return !l.entries[i].less(&e)
+	})
+	if i < len(l.entries) && l.entries[i].id == id {
+		l.entries = append(l.entries[:i], l.entries[i+1:]...)
+		return i
+	}
+	return -1
+}
+
+func (l *LowPricedList) encode() {
+	n := len(l.entries)
+	if n > Limit {
+		n = Limit
+	}
+	rows := make([]interface{}, 0, n)
+	for _, e := range l.entries[:n] {
+		rows = append(rows, e.row)
+	}
+	body, err := jsoniter.Marshal(l.response(rows))
+	if err != nil {
+		logger.Errorf("low priced %s encode err: %s", l.name, err)
+		return
+	}
+	l.body = body
+}
+
+// JSON the encoded response, callers must not modify it
+func (l *LowPricedList) JSON() []byte {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.body
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestLowPricedListAddRemove(t *testing.T) {
+	r := rand.New(rand.NewSource(8))
+	entry := func(id int64) lowPricedEntry {
+		// few prices so ties are ordered by id
+		return lowPricedEntry{price: int64(r.Intn(20)), id: id, row: &Estate{ID: id}}
+	}
+
+	l := NewLowPricedList("estate", func(rows []interface{}) interface{} { return len(rows) })
+	want := make(map[int64]lowPricedEntry)
+	initial := make([]lowPricedEntry, 0)
+	for id := int64(1); id <= 200; id++ {
+		e := entry(id)
+		initial = append(initial, e)
+		want[id] = e
+	}
+	l.Load(initial)
+
+	for i := 0; i < 2000; i++ {
+		id := int64(1 + r.Intn(300))
+		if r.Intn(2) == 0 {
+			// inserts new rows and replaces existing ones with a new price
+			e := entry(id)
+			l.Add(e)
+			want[id] = e
+		} else {
+			l.Remove(id)
+			delete(want, id)
+		}
+	}
+
+	entries := make([]lowPricedEntry, 0, len(want))
+	for _, e := range want {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].less(&entries[j]) })
+	wantIDs := make([]int64, 0, len(entries))
+	for _, e := range entries {
+		wantIDs = append(wantIDs, e.id)
+	}
+	if got := l.IDs(); fmt.Sprint(got) != fmt.Sprint(wantIDs) {
+		t.Errorf("ids = %v, want %v", got, wantIDs)
+	}
+	if len(l.byID) != len(want) {
+		t.Errorf("byID has %d rows, want %d", len(l.byID), len(want))
+	}
+}
@@ -8,19 +8,15 @@ import (
     "net/http"
     _ "net/http/pprof"
     "os"
-    "os/exec"
-    "path/filepath"
     "strconv"
     "strings"
     "time"
 
     // "github.com/gchaincl/sqlhooks/v2"
-    "github.com/go-redis/redis/v8"
     _ "github.com/go-sql-driver/mysql"
     "github.com/gofiber/fiber/v2"
     midLogger "github.com/gofiber/fiber/v2/middleware/logger"
     "github.com/kellydunn/golang-geo"
-    "github.com/spf13/cast"
 )
 
 // TODO debug remove
@@ -32,9 +28,15 @@ func main() {
     // pprof
     go http.ListenAndServe("127.0.0.1:9090", nil)
 
+    loadSearchConditions()
     initLogger()
+    initCache()
+    initChairSearcher()
+    initNazotteSearcher()
 
-    s := fiber.New()
+    s := fiber.New(fiber.Config{
+        ErrorHandler: errorHandler,
+    })
     routeRegister(s)
 
     if os.Getenv("ENV") == "dev" {
@@ -55,6 +57,13 @@ func main() {
         logger.Errorf("DB Connect err: %s", err)
     }
 
+    writeQueue, err = openWriteQueue(getEnv("WRITE_QUEUE_PATH", "write_queue.log"))
+    if err != nil {
+        logger.Fatalf("write queue open failed : %v", err)
+    }
+    go writeQueue.run()
+    startReconciler()
+
     tablesCache()
 
     // Start server
@@ -63,34 +72,22 @@ func main() {
 }
 
 func initialize(c *fiber.Ctx) error {
-    sqlDir := filepath.Join("..", "mysql", "db")
-    paths := []string{
-        filepath.Join(".", "0_Schema.sql"),
-        filepath.Join(".", "0_Index.sql"),
-        filepath.Join(sqlDir, "1_DummyEstateData.sql"),
-        filepath.Join(sqlDir, "2_DummyChairData.sql"),
+    if err := writeQueue.Reset(); err != nil {
+        logger.Errorf("write queue reset err: %s", err)
     }
 
-    for _, p := range paths {
-        sqlFile, _ := filepath.Abs(p)
-        cmdStr := fmt.Sprintf("mysql -h %v -u %v -p%v -P %v %v < %v",
-            mySQLConnectionData.Host,
-            mySQLConnectionData.User,
-            mySQLConnectionData.Password,
-            mySQLConnectionData.Port,
-            mySQLConnectionData.DBName,
-            sqlFile,
-        )
-        if err := exec.Command("bash", "-c", cmdStr).Run(); err != nil {
-            logger.Errorf("Initialize script error : %v", err)
-            return c.SendStatus(http.StatusInternalServerError)
-        }
+    chairs, estates, err := tableSnapshot.Restore(context.Background())
+    if err != nil {
+        logger.Errorf("Initialize script error : %v", err)
+        return errInternal
     }
-    if err := redisClient.FlushAll(context.Background()).Err(); err != nil {
-        logger.Errorf("redis flush err: %s", err)
+    // the cache is shared with the other servers and may hold rows posted
+    // since, so it is refilled; the in-memory indexes come from the snapshot
+    if err := cache.FlushAll(context.Background()); err != nil {
+        logger.Errorf("cache flush err: %s", err)
     }
-
-    tablesCache()
+    cacheRows(chairs, estates)
+    restoreIndexes(chairs, estates)
 
     return c.JSON(InitializeResponse{
         Language: "go",
@@ -98,260 +95,203 @@ func initialize(c *fiber.Ctx) error {
 }
 
 func getChairDetail(c *fiber.Ctx) error {
-    id, err := strconv.Atoi(c.Params("id"))
+    id, err := validateID("id", c.Params("id"))
     if err != nil {
-        logger.Errorf("Request parameter \"id\" parse error : %v", err)
-        return c.SendStatus(http.StatusBadRequest)
+        return err
     }
 
-    val, err := redisClient.Get(context.Background(), CacheKeyChairID+c.Params("id")).Result()
+    val, err := cache.Get(context.Background(), CacheKeyChairID+c.Params("id"))
     if err != nil {
-        if err == redis.Nil {
-            logger.Infof("requested id's chair not found : %v", id)
-            return c.SendStatus(http.StatusNotFound)
+        if err == errCacheMiss {
+            return newAPIError(http.StatusNotFound, "chair_not_found", "chair %d not found", id)
         }
         logger.Errorf("Failed to get the chair from id : %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
+        return errInternal
     }
     c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
     return c.SendString(val)
-
-    chair := Chair{}
-    query := `SELECT * FROM chair WHERE id = ?`
-    err = db.Get(&chair, query, id)
-    if err != nil {
-        if err == sql.ErrNoRows {
-            logger.Infof("requested id's chair not found : %v", id)
-            return c.SendStatus(http.StatusNotFound)
-        }
-        logger.Errorf("Failed to get the chair from id : %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
-    } else if chair.Stock <= 0 {
-        logger.Infof("requested id's chair is sold out : %v", id)
-        return c.SendStatus(http.StatusNotFound)
-    }
-
-    return c.JSON(chair)
 }
 
 func postChair(c *fiber.Ctx) error {
     header, err := c.FormFile("chairs")
     if err != nil {
-        logger.Errorf("failed to get form file: %v", err)
-        return c.SendStatus(http.StatusBadRequest)
+        return invalid("chairs", "%s", err)
     }
     f, err := header.Open()
     if err != nil {
         logger.Errorf("failed to open form file: %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
+        return errInternal
     }
     defer f.Close()
     records, err := csv.NewReader(f).ReadAll()
     if err != nil {
-        logger.Errorf("failed to read csv: %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
+        return invalid("chairs", "%s", err)
     }
 
     // build rows
     rows := make([]*Chair, 0, len(records))
-    pipe := redisClient.Pipeline()
-    for _, record := range records {
-        rm := RecordMapper{Record: record}
-        id := rm.NextInt()
-        name := rm.NextString()
-        description := rm.NextString()
-        thumbnail := rm.NextString()
-        price := rm.NextInt()
-        height := rm.NextInt()
-        width := rm.NextInt()
-        depth := rm.NextInt()
-        color := rm.NextString()
-        features := rm.NextString()
-        kind := rm.NextString()
-        popularity := rm.NextInt()
-        stock := rm.NextInt()
-        if err := rm.Err(); err != nil {
-            logger.Errorf("failed to read record: %v", err)
-            return c.SendStatus(http.StatusBadRequest)
-        }
-        row := &Chair{
-            ID:          cast.ToInt64(id),
-            Name:        name,
-            Description: description,
-            Thumbnail:   thumbnail,
-            Price:       cast.ToInt64(price),
-            Height:      cast.ToInt64(height),
-            Width:       cast.ToInt64(width),
-            Depth:       cast.ToInt64(depth),
-            Color:       color,
-            Features:    features,
-            Kind:        kind,
-            Popularity:  cast.ToInt64(popularity),
-            Stock:       cast.ToInt64(stock),
+    pipe := cache.Pipeline()
+    for i, record := range records {
+        row, err := parseChairRecord(record)
+        if err != nil {
+            return invalid("chairs", "record %d: %s", i+1, err)
         }
         // cache
-        cacheRow(CacheKeyChairID, row.ID, row, pipe)
+        if row.Stock > 0 {
+            cacheRow(CacheKeyChairID, row.ID, row, pipe)
+        }
         rows = append(rows, row)
     }
+    cacheChairStock(pipe, rows...)
 
-    go func() {
-        tx, err := db.Begin()
-        if err != nil {
-            logger.Errorf("failed to begin tx: %v", err)
-            return
-        }
-        defer tx.Rollback()
-        for _, row := range rows {
-            _, err := tx.Exec("INSERT INTO chair(id, name, description, thumbnail, price, height, width, depth, color, features, kind, popularity, stock) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?)", row.ID, row.Name, row.Description, row.Thumbnail, row.Price, row.Height, row.Width, row.Depth, row.Color, row.Features, row.Kind, row.Popularity, row.Stock)
-            if err != nil {
-                logger.Errorf("failed to insert chair: %v", err)
-                return
-            }
-        }
-        if err := tx.Commit(); err != nil {
-            logger.Errorf("failed to commit tx: %v", err)
-            return
-        }
-    }()
+    release := writeQueue.Enter()
+    defer release()
+
+    if err := writeQueue.Enqueue(&writeJob{Op: writeOpInsertChairs, Records: records}); err != nil {
+        logger.Errorf("failed to enqueue chairs: %v", err)
+        return errInternal
+    }
+
+    if err := pipe.Exec(context.Background()); err != nil {
+        logger.Errorf("cache chair err: %s", err)
+    }
+
+    if err := cacheChair(rows...); err != nil {
+        logger.Errorf("cache chair err: %s", err)
+    }
+
+    chairIndex.Add(rows...)
+    chairFitKeys.Add(rows...)
+    lowPricedChairs.Add(chairLowPriced(rows...)...)
+    chairTextIndex.Add(chairTextDocs(rows...)...)
+    responseCache.Invalidate(responseChair)
 
     return c.SendStatus(http.StatusCreated)
 }
 
 func searchChairs(c *fiber.Ctx) error {
-    conditions := make([]string, 0)
-    params := make([]interface{}, 0)
-
-    tx := redisClient.TxPipeline()
+    hasCondition := false
+    q := ChairQuery{}
 
     if c.Query("priceRangeId") != "" {
-        chairPrice, err := getRange(chairSearchCondition.Price, c.Query("priceRangeId"))
+        chairPrice, err := validateRangeIDs("priceRangeId", chairSearchCondition.Price, c.Query("priceRangeId"))
         if err != nil {
-            logger.Infof("priceRangeID invalid, %v : %v", c.Query("priceRangeId"), err)
-            return c.SendStatus(http.StatusBadRequest)
-        }
-        
-        if chairPrice.Min != -1 {
-            conditions = append(conditions, "price >= ?")
-            params = append(params, chairPrice.Min)
+            return err
         }
-        if chairPrice.Max != -1 {
-            conditions = append(conditions, "price < ?")
-            params = append(params, chairPrice.Max)
+
+        if chairPrice != 0 {
+            hasCondition = true
+            q.Price = chairPrice
         }
     }
 
     if c.Query("heightRangeId") != "" {
-        chairHeight, err := getRange(chairSearchCondition.Height, c.Query("heightRangeId"))
+        chairHeight, err := validateRangeIDs("heightRangeId", chairSearchCondition.Height, c.Query("heightRangeId"))
         if err != nil {
-            logger.Infof("heightRangeIf invalid, %v : %v", c.Query("heightRangeId"), err)
-            return c.SendStatus(http.StatusBadRequest)
+            return err
         }
 
-        if chairHeight.Min != -1 {
-            conditions = append(conditions, "height >= ?")
-            params = append(params, chairHeight.Min)
-        }
-        if chairHeight.Max != -1 {
-            conditions = append(conditions, "height < ?")
-            params = append(params, chairHeight.Max)
+        if chairHeight != 0 {
+            hasCondition = true
+            q.Height = chairHeight
         }
     }
 
     if c.Query("widthRangeId") != "" {
-        chairWidth, err := getRange(chairSearchCondition.Width, c.Query("widthRangeId"))
+        chairWidth, err := validateRangeIDs("widthRangeId", chairSearchCondition.Width, c.Query("widthRangeId"))
         if err != nil {
-            logger.Infof("widthRangeID invalid, %v : %v", c.Query("widthRangeId"), err)
-            return c.SendStatus(http.StatusBadRequest)
+            return err
         }
 
-        if chairWidth.Min != -1 {
-            conditions = append(conditions, "width >= ?")
-            params = append(params, chairWidth.Min)
-        }
-        if chairWidth.Max != -1 {
-            conditions = append(conditions, "width < ?")
-            params = append(params, chairWidth.Max)
+        if chairWidth != 0 {
+            hasCondition = true
+            q.Width = chairWidth
         }
     }
 
     if c.Query("depthRangeId") != "" {
-        chairDepth, err := getRange(chairSearchCondition.Depth, c.Query("depthRangeId"))
+        chairDepth, err := validateRangeIDs("depthRangeId", chairSearchCondition.Depth, c.Query("depthRangeId"))
         if err != nil {
-            logger.Infof("depthRangeId invalid, %v : %v", c.Query("depthRangeId"), err)
-            return c.SendStatus(http.StatusBadRequest)
+            return err
         }
 
-        if chairDepth.Min != -1 {
-            conditions = append(conditions, "depth >= ?")
-            params = append(params, chairDepth.Min)
-        }
-        if chairDepth.Max != -1 {
-            conditions = append(conditions, "depth < ?")
-            params = append(params, chairDepth.Max)
+        if chairDepth != 0 {
+            hasCondition = true
+            q.Depth = chairDepth
         }
     }
 
     if c.Query("kind") != "" {
-        conditions = append(conditions, "kind = ?")
-        params = append(params, c.Query("kind"))
+        kind, err := validateList("kind", chairSearchCondition.Kind, c.Query("kind"))
+        if err != nil {
+            return err
+        }
+
+        hasCondition = true
+        q.Kind = kind
     }
 
     if c.Query("color") != "" {
-        conditions = append(conditions, "color = ?")
-        params = append(params, c.Query("color"))
+        color, err := validateList("color", chairSearchCondition.Color, c.Query("color"))
+        if err != nil {
+            return err
+        }
+
+        hasCondition = true
+        q.Color = color
     }
 
     if c.Query("features") != "" {
-        for _, f := range strings.Split(c.Query("features"), ",") {
-            // conditions = append(conditions, "features LIKE '%?%'")
-            conditions = append(conditions, "features LIKE CONCAT('%', ?, '%')")
-            params = append(params, f)
+        features, err := validateFeatures("features", chairSearchCondition.Feature, c.Query("features"))
+        if err != nil {
+            return err
         }
+
+        hasCondition = true
+        q.Features = features
     }
 
-    if len(conditions) == 0 {
-        logger.Infof("Search condition not found")
-        return c.SendStatus(http.StatusBadRequest)
+    if c.Query("q") != "" {
+        terms, err := parseTextQuery(c.Query("q"))
+        if err != nil {
+            return err
+        }
+
+        hasCondition = true
+        q.Text = chairTextIndex.Match(terms)
     }
 
-    conditions = append(conditions, "stock > 0")
+    if !hasCondition {
+        return invalid("query", "no search condition")
+    }
 
-    page, err := strconv.Atoi(c.Query("page"))
+    order, err := getChairSort(c.Query("sort"))
     if err != nil {
-        logger.Infof("Invalid format page parameter : %v", err)
-        return c.SendStatus(http.StatusBadRequest)
+        return invalid("sort", "unknown sort %q", c.Query("sort"))
     }
+    q.Sort = order
 
-    perPage, err := strconv.Atoi(c.Query("perPage"))
+    page, err := parseSearchPage(c, order.name)
     if err != nil {
-        logger.Infof("Invalid format perPage parameter : %v", err)
-        return c.SendStatus(http.StatusBadRequest)
+        return err
+    }
+    q.After = page.After
+    if page.Facets {
+        q.Facets = newChairFacets()
     }
-
-    searchQuery := "SELECT * FROM chair WHERE "
-    countQuery := "SELECT COUNT(*) FROM chair WHERE "
-    searchCondition := strings.Join(conditions, " AND ")
-    limitOffset := " ORDER BY popularity_desc, id LIMIT ? OFFSET ?"
 
     var res ChairSearchResponse
-    err = db.Get(&res.Count, countQuery+searchCondition, params...)
+    res.Count, res.Chairs, err = chairSearcher.Search(&q, page.Offset, page.Limit)
     if err != nil {
         logger.Errorf("searchChairs DB execution error : %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
+        return errInternal
     }
-
-    chairs := []Chair{}
-    params = append(params, perPage, page*perPage)
-    err = db.Select(&chairs, searchQuery+searchCondition+limitOffset, params...)
-    if err != nil {
-        if err == sql.ErrNoRows {
-            return c.JSON(ChairSearchResponse{Count: 0, Chairs: []Chair{}})
-        }
-        logger.Errorf("searchChairs DB execution error : %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
+    if page.more(len(res.Chairs)) {
+        res.Chairs = res.Chairs[:page.PerPage]
+        last := res.Chairs[page.PerPage-1]
+        res.NextCursor = newSearchCursor(order.name, order.key(&last), last.ID).String()
     }
-
-    res.Chairs = chairs
+    res.Facets = q.Facets
 
     return c.JSON(res)
 }
@@ -363,71 +303,40 @@ type buyParams struct {
 func buyChair(c *fiber.Ctx) error {
     params := new(buyParams)
     if err := c.BodyParser(params); err != nil {
-        logger.Infof("post buy chair failed : %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
+        return invalid("body", "%s", err)
     }
 
-    if params.Email == "" {
-        logger.Info("post buy chair failed : email not found in request body")
-        return c.SendStatus(http.StatusBadRequest)
+    if _, err := validateEmail("email", params.Email); err != nil {
+        return err
     }
 
-    id, err := strconv.Atoi(c.Params("id"))
+    id, err := validateID("id", c.Params("id"))
     if err != nil {
-        logger.Infof("post buy chair failed : %v", err)
-        return c.SendStatus(http.StatusBadRequest)
+        return err
     }
 
-    stock, err := redisClient.Get(context.Background(), cacheKey("chair", "stock", cast.ToString(id))).Int()
+    release := writeQueue.Enter()
+    defer release()
+
+    reservation, err := reserveChair(id)
     if err != nil {
-        if err == redis.Nil {
-            return c.SendStatus(http.StatusBadRequest)
+        switch err {
+        case errChairNotFound:
+            return newAPIError(http.StatusBadRequest, "chair_not_found", "chair %d not found", id)
+        case errChairSoldOut:
+            return newAPIError(http.StatusBadRequest, "chair_sold_out", "chair %d is sold out", id)
         }
-        logger.Errorf("redis err: %s", err)
-        return c.SendStatus(http.StatusInternalServerError)
+        logger.Errorf("chair stock reserve err: %s, id: %v", err, id)
+        return errInternal
     }
 
-    if stock <= 0 {
-        logger.Infof("chair stock <= 0, id: %v", id)
-        return c.SendStatus(http.StatusBadRequest)
-    }
-
-    if err := redisClient.DecrBy(context.Background(), cacheKey("chair", "stock", cast.ToString(id)), 1).Err(); err != nil {
-        logger.Errorf("chair stock decr err: %s, id: %v", err, id)
-        return c.SendStatus(http.StatusBadRequest)
-    }
-
-    go func() {
-        tx, err := db.Beginx()
-        if err != nil {
-            logger.Errorf("failed to create transaction : %v", err)
-            return
+    if err := writeQueue.Enqueue(&writeJob{Op: writeOpBuyChair, ChairID: id}); err != nil {
+        logger.Errorf("failed to enqueue buy chair: %v, id: %v", err, id)
+        if err := cancelChairReservation(reservation); err != nil {
+            logger.Errorf("failed to cancel chair reservation: %v, id: %v", err, id)
         }
-        defer tx.Rollback()
-    
-        var chair Chair
-        err = tx.QueryRowx("SELECT * FROM chair WHERE id = ? AND stock > 0 FOR UPDATE", id).StructScan(&chair)
-        if err != nil {
-            if err == sql.ErrNoRows {
-                logger.Infof("buyChair chair id \"%v\" not found", id)
-                return 
-            }
-            logger.Errorf("DB Execution Error: on getting a chair by id : %v", err)
-            return 
-        }
-    
-        _, err = tx.Exec("UPDATE chair SET stock = stock - 1 WHERE id = ?", id)
-        if err != nil {
-            logger.Errorf("chair stock update failed : %v", err)
-            return
-        }
-    
-        err = tx.Commit()
-        if err != nil {
-            logger.Errorf("transaction commit error : %v", err)
-            return
-        }
-    }()
+        return errInternal
+    }
 
     return c.SendStatus(http.StatusOK)
 }
@@ -437,52 +346,26 @@ func getChairSearchCondition(c *fiber.Ctx) error {
 }
 
 func getLowPricedChair(c *fiber.Ctx) error {
-    var chairs []Chair
-    query := `SELECT * FROM chair WHERE stock > 0 ORDER BY price ASC, id ASC LIMIT ?`
-    err := db.Select(&chairs, query, Limit)
-    if err != nil {
-        if err == sql.ErrNoRows {
-            logger.Error("getLowPricedChair not found")
-            return c.JSON(ChairListResponse{[]Chair{}})
-        }
-        logger.Errorf("getLowPricedChair DB execution error : %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
-    }
-
-    return c.JSON(ChairListResponse{Chairs: chairs})
+    c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+    return c.Send(lowPricedChairs.JSON())
 }
 
 func getEstateDetail(c *fiber.Ctx) error {
-    id, err := strconv.Atoi(c.Params("id"))
+    id, err := validateID("id", c.Params("id"))
     if err != nil {
-        logger.Infof("Request parameter \"id\" parse error : %v", err)
-        return c.SendStatus(http.StatusBadRequest)
+        return err
     }
 
-    val, err := redisClient.Get(context.Background(), CacheKeyEstateID+c.Params("id")).Result()
+    val, err := cache.Get(context.Background(), CacheKeyEstateID+c.Params("id"))
     if err != nil {
-        if err == redis.Nil {
-            logger.Infof("requested id's estate not found : %v", id)
-            return c.SendStatus(http.StatusNotFound)
+        if err == errCacheMiss {
+            return newAPIError(http.StatusNotFound, "estate_not_found", "estate %d not found", id)
         }
         logger.Errorf("Failed to get the estate from id : %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
+        return errInternal
     }
     c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
     return c.SendString(val)
-
-    var estate Estate
-    err = db.Get(&estate, "SELECT * FROM estate WHERE id = ?", id)
-    if err != nil {
-        if err == sql.ErrNoRows {
-            logger.Infof("getEstateDetail estate id %v not found", id)
-            return c.SendStatus(http.StatusNotFound)
-        }
-        logger.Errorf("Database Execution error : %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
-    }
-
-    return c.JSON(estate)
 }
 
 func getRange(cond RangeCondition, rangeID string) (*Range, error) {
@@ -498,350 +381,341 @@ func getRange(cond RangeCondition, rangeID string) (*Range, error) {
     return cond.Ranges[RangeIndex], nil
 }
 
+// getRangeMask ORs the comma separated range IDs, 0 when one of them is unbounded
+func getRangeMask(cond RangeCondition, rangeIDs string) (uint64, error) {
+    var mask uint64
+    unbounded := false
+    for _, rangeID := range strings.Split(rangeIDs, ",") {
+        r, err := getRange(cond, rangeID)
+        if err != nil {
+            return 0, err
+        }
+        if !r.bounded() {
+            unbounded = true
+        }
+        mask |= r.bit()
+    }
+    if unbounded {
+        return 0, nil
+    }
+    return mask, nil
+}
+
+// getListFilter the comma separated values, each of them must be in cond
+func getListFilter(cond ListCondition, values string) (listFilter, error) {
+    f := listFilter{}
+    for _, v := range strings.Split(values, ",") {
+        if indexOf(cond.List, v) < 0 {
+            return nil, fmt.Errorf("unknown value %q", v)
+        }
+        if indexOf(f, v) < 0 {
+            f = append(f, v)
+        }
+    }
+    return f, nil
+}
+
 func postEstate(c *fiber.Ctx) error {
     header, err := c.FormFile("estates")
     if err != nil {
-        logger.Errorf("failed to get form file: %v", err)
-        return c.SendStatus(http.StatusBadRequest)
+        return invalid("estates", "%s", err)
     }
     f, err := header.Open()
     if err != nil {
         logger.Errorf("failed to open form file: %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
+        return errInternal
     }
     defer f.Close()
     records, err := csv.NewReader(f).ReadAll()
     if err != nil {
-        logger.Errorf("failed to read csv: %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
+        return invalid("estates", "%s", err)
     }
 
-    tx, err := db.Begin()
-    if err != nil {
-        logger.Errorf("failed to begin tx: %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
-    }
-    defer tx.Rollback()
-
     rows := make([]*Estate, 0, len(records))
-    pipe := redisClient.Pipeline()
-    for _, record := range records {
-        rm := RecordMapper{Record: record}
-        id := rm.NextInt()
-        name := rm.NextString()
-        description := rm.NextString()
-        thumbnail := rm.NextString()
-        address := rm.NextString()
-        latitude := rm.NextFloat()
-        longitude := rm.NextFloat()
-        rent := rm.NextInt()
-        doorHeight := rm.NextInt()
-        doorWidth := rm.NextInt()
-        features := rm.NextString()
-        popularity := rm.NextInt()
-        if err := rm.Err(); err != nil {
-            logger.Errorf("failed to read record: %v", err)
-            return c.SendStatus(http.StatusBadRequest)
-        }
-        row := &Estate{
-            ID:             cast.ToInt64(id),
-            Thumbnail:      thumbnail,
-            Name:           name,
-            Description:    description,
-            Latitude:       latitude,
-            Longitude:      longitude,
-            Address:        address,
-            Rent:           cast.ToInt64(rent),
-            DoorHeight:     cast.ToInt64(doorHeight),
-            DoorWidth:      cast.ToInt64(doorWidth),
-            Features:       features,
-            Popularity:     cast.ToInt64(popularity),
+    pipe := cache.Pipeline()
+    for i, record := range records {
+        row, err := parseEstateRecord(record)
+        if err != nil {
+            return invalid("estates", "record %d: %s", i+1, err)
         }
         cacheRow(CacheKeyEstateID, row.ID, row, pipe)
         rows = append(rows, row)
     }
 
-    go func(){
-        for _, row := range rows {
-            _, err := tx.Exec("INSERT INTO estate(id, name, description, thumbnail, address, latitude, longitude, rent, door_height, door_width, features, popularity) VALUES(?,?,?,?,?,?,?,?,?,?,?,?)", row.ID, row.Name, row.Description, row.Thumbnail, row.Address, row.Latitude, row.Longitude, row.Rent, row.DoorHeight, row.DoorWidth, row.Features, row.Popularity)
-            if err != nil {
-                logger.Errorf("failed to insert estate: %v", err)
-                return
-            }
-        }
-        if err := tx.Commit(); err != nil {
-            logger.Errorf("failed to commit tx: %v", err)
-            return
-        }
-    }()
+    release := writeQueue.Enter()
+    defer release()
+
+    if err := writeQueue.Enqueue(&writeJob{Op: writeOpInsertEstates, Records: records}); err != nil {
+        logger.Errorf("failed to enqueue estates: %v", err)
+        return errInternal
+    }
+
+    if err := pipe.Exec(context.Background()); err != nil {
+        logger.Errorf("cache estate err: %s", err)
+    }
+
+    estateIndex.Add(rows...)
+    estateGeoIndex.Add(rows...)
+    estateFitIndex.Add(rows...)
+    lowPricedEstates.Add(estateLowPriced(rows...)...)
+    estateTextIndex.Add(estateTextDocs(rows...)...)
+    responseCache.Invalidate(responseEstate)
 
     return c.SendStatus(http.StatusCreated)
 }
 
-// TODO inmemory search
-func searchEstates(c *fiber.Ctx) error {
-    conditions := make([]string, 0)
-    params := make([]interface{}, 0)
-
+// parseEstateFilters the rent, door, feature and q conditions shared by the
+// estate searches, hasCondition reports whether any was given
+func parseEstateFilters(c *fiber.Ctx) (q EstateQuery, hasCondition bool, err error) {
     if c.Query("doorHeightRangeId") != "" {
-        doorHeight, err := getRange(estateSearchCondition.DoorHeight, c.Query("doorHeightRangeId"))
+        doorHeight, err := validateRangeIDs("doorHeightRangeId", estateSearchCondition.DoorHeight, c.Query("doorHeightRangeId"))
         if err != nil {
-            logger.Infof("doorHeightRangeID invalid, %v : %v", c.Query("doorHeightRangeId"), err)
-            return c.SendStatus(http.StatusBadRequest)
+            return q, false, err
         }
 
-        if doorHeight.Min != -1 {
-            conditions = append(conditions, "door_height >= ?")
-            params = append(params, doorHeight.Min)
-        }
-        if doorHeight.Max != -1 {
-            conditions = append(conditions, "door_height < ?")
-            params = append(params, doorHeight.Max)
+        if doorHeight != 0 {
+            hasCondition = true
+            q.DoorHeight = doorHeight
         }
     }
 
     if c.Query("doorWidthRangeId") != "" {
-        doorWidth, err := getRange(estateSearchCondition.DoorWidth, c.Query("doorWidthRangeId"))
+        doorWidth, err := validateRangeIDs("doorWidthRangeId", estateSearchCondition.DoorWidth, c.Query("doorWidthRangeId"))
         if err != nil {
-            logger.Infof("doorWidthRangeID invalid, %v : %v", c.Query("doorWidthRangeId"), err)
-            return c.SendStatus(http.StatusBadRequest)
+            return q, false, err
         }
 
-        if doorWidth.Min != -1 {
-            conditions = append(conditions, "door_width >= ?")
-            params = append(params, doorWidth.Min)
-        }
-        if doorWidth.Max != -1 {
-            conditions = append(conditions, "door_width < ?")
-            params = append(params, doorWidth.Max)
+        if doorWidth != 0 {
+            hasCondition = true
+            q.DoorWidth = doorWidth
         }
     }
 
     if c.Query("rentRangeId") != "" {
-        estateRent, err := getRange(estateSearchCondition.Rent, c.Query("rentRangeId"))
+        estateRent, err := validateRangeIDs("rentRangeId", estateSearchCondition.Rent, c.Query("rentRangeId"))
         if err != nil {
-            logger.Infof("rentRangeID invalid, %v : %v", c.Query("rentRangeId"), err)
-            return c.SendStatus(http.StatusBadRequest)
+            return q, false, err
         }
 
-        if estateRent.Min != -1 {
-            conditions = append(conditions, "rent >= ?")
-            params = append(params, estateRent.Min)
-        }
-        if estateRent.Max != -1 {
-            conditions = append(conditions, "rent < ?")
-            params = append(params, estateRent.Max)
+        if estateRent != 0 {
+            hasCondition = true
+            q.Rent = estateRent
         }
     }
 
     if c.Query("features") != "" {
-        for _, f := range strings.Split(c.Query("features"), ",") {
-            // conditions = append(conditions, "features like '%?%'")
-            conditions = append(conditions, "features like concat('%', ?, '%')")
-            params = append(params, f)
+        features, err := validateFeatures("features", estateSearchCondition.Feature, c.Query("features"))
+        if err != nil {
+            return q, false, err
         }
-    }
 
-    if len(conditions) == 0 {
-        logger.Infof("searchEstates search condition not found")
-        return c.SendStatus(http.StatusBadRequest)
+        hasCondition = true
+        q.Features = features
     }
 
-    page, err := strconv.Atoi(c.Query("page"))
-    if err != nil {
-        logger.Infof("Invalid format page parameter : %v", err)
-        return c.SendStatus(http.StatusBadRequest)
+    if c.Query("q") != "" {
+        terms, err := parseTextQuery(c.Query("q"))
+        if err != nil {
+            return q, false, err
+        }
+
+        hasCondition = true
+        q.Text = estateTextIndex.Match(terms)
     }
 
-    perPage, err := strconv.Atoi(c.Query("perPage"))
+    return q, hasCondition, nil
+}
+
+func searchEstates(c *fiber.Ctx) error {
+    q, hasCondition, err := parseEstateFilters(c)
     if err != nil {
-        logger.Infof("Invalid format perPage parameter : %v", err)
-        return c.SendStatus(http.StatusBadRequest)
+        return err
     }
 
-    searchQuery := "SELECT * FROM estate WHERE "
-    countQuery := "SELECT COUNT(*) FROM estate WHERE "
-    searchCondition := strings.Join(conditions, " AND ")
-    limitOffset := " ORDER BY popularity_desc, id LIMIT ? OFFSET ?"
+    if !hasCondition {
+        return invalid("query", "no search condition")
+    }
 
-    var res EstateSearchResponse
-    err = db.Get(&res.Count, countQuery+searchCondition, params...)
+    order, err := getEstateSort(c.Query("sort"))
     if err != nil {
-        logger.Errorf("searchEstates DB execution error : %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
+        return invalid("sort", "unknown sort %q", c.Query("sort"))
     }
+    q.Sort = order
 
-    estates := []Estate{}
-    params = append(params, perPage, page*perPage)
-    err = db.Select(&estates, searchQuery+searchCondition+limitOffset, params...)
+    page, err := parseSearchPage(c, order.name)
     if err != nil {
-        if err == sql.ErrNoRows {
-            return c.JSON(EstateSearchResponse{Count: 0, Estates: []Estate{}})
-        }
-        logger.Errorf("searchEstates DB execution error : %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
+        return err
+    }
+    q.After = page.After
+    if page.Facets {
+        q.Facets = newEstateFacets()
     }
 
-    res.Estates = estates
+    var res EstateSearchResponse
+    res.Count, res.Estates = estateIndex.Search(&q, page.Offset, page.Limit)
+    if page.more(len(res.Estates)) {
+        res.Estates = res.Estates[:page.PerPage]
+        last := res.Estates[page.PerPage-1]
+        res.NextCursor = newSearchCursor(order.name, order.key(&last), last.ID).String()
+    }
+    res.Facets = q.Facets
 
     return c.JSON(res)
 }
 
-// TODO cache
 func getLowPricedEstate(c *fiber.Ctx) error {
-    estates := make([]Estate, 0, Limit)
-    query := `SELECT * FROM estate ORDER BY rent ASC, id ASC LIMIT ?`
-    err := db.Select(&estates, query, Limit)
-    if err != nil {
-        if err == sql.ErrNoRows {
-            logger.Error("getLowPricedEstate not found")
-            return c.JSON(EstateListResponse{[]Estate{}})
-        }
-        logger.Errorf("getLowPricedEstate DB execution error : %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
-    }
-
-    return c.JSON(EstateListResponse{Estates: estates})
+    c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+    return c.Send(lowPricedEstates.JSON())
 }
 
-// TODO inmemory search
 func searchRecommendedEstateWithChair(c *fiber.Ctx) error {
-    id, err := strconv.Atoi(c.Params("id"))
-    if err != nil {
-        logger.Infof("Invalid format searchRecommendedEstateWithChair id : %v", err)
-        return c.SendStatus(http.StatusBadRequest)
-    }
-
-    chair := Chair{}
-    query := `SELECT * FROM chair WHERE id = ?`
-    err = db.Get(&chair, query, id)
+    id, err := validateID("id", c.Params("id"))
     if err != nil {
-        if err == sql.ErrNoRows {
-            logger.Infof("Requested chair id \"%v\" not found", id)
-            return c.SendStatus(http.StatusBadRequest)
-        }
-        logger.Errorf("Database execution error : %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
+        return err
     }
 
-    var estates []Estate
-    w := chair.Width
-    h := chair.Height
-    d := chair.Depth
-    query = `SELECT * FROM estate WHERE (door_width >= ? AND door_height >= ?) OR (door_width >= ? AND door_height >= ?) OR (door_width >= ? AND door_height >= ?) OR (door_width >= ? AND door_height >= ?) OR (door_width >= ? AND door_height >= ?) OR (door_width >= ? AND door_height >= ?) ORDER BY popularity_desc, id LIMIT ?`
-    err = db.Select(&estates, query, w, h, w, d, h, w, h, d, d, w, d, h, Limit)
-    if err != nil {
-        if err == sql.ErrNoRows {
-            return c.JSON(EstateListResponse{[]Estate{}})
-        }
-        logger.Errorf("Database execution error : %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
+    // chair:id: is dropped with the last stock, the sizes are kept for every chair
+    key, ok := chairFitKeys.Get(id)
+    if !ok {
+        return newAPIError(http.StatusBadRequest, "chair_not_found", "chair %d not found", id)
     }
 
-    return c.JSON(EstateListResponse{Estates: estates})
+    return c.JSON(EstateListResponse{Estates: estateFitIndex.Fit(key)})
 }
 
 func searchEstateNazotte(c *fiber.Ctx) error {
     start := time.Now()
 
-    coordinates := Coordinates{}
-    err := c.BodyParser(&coordinates)
+    geoJSON, err := wantsGeoJSON(c)
+    if err != nil {
+        return err
+    }
+
+    req := nazotteRequest{}
+    err = c.BodyParser(&req)
     if err != nil {
-        logger.Infof("post search estate nazotte failed : %v", err)
-        return c.SendStatus(http.StatusBadRequest)
+        return invalid("body", "%s", err)
     }
 
-    if len(coordinates.Coordinates) == 0 {
-        return c.SendStatus(http.StatusBadRequest)
+    area, err := req.area()
+    if err != nil {
+        return err
+    }
+    if err := area.normalize(); err != nil {
+        return err
     }
 
     // debug, TODO remove
     defer func() {
         duration := time.Since(start)
-        logger.With("params", coordinates).Infof("request: post search estate nazotte, duration: %s", duration.String())
+        logger.With("params", area).Infof("request: post search estate nazotte, duration: %s", duration.String())
     }()
 
-    b := coordinates.getBoundingBox()
-    estatesInBoundingBox := []Estate{}
-    query := `SELECT * FROM estate WHERE latitude <= ? AND latitude >= ? AND longitude <= ? AND longitude >= ? ORDER BY popularity_desc, id`
-    err = db.Select(&estatesInBoundingBox, query, b.BottomRightCorner.Latitude, b.TopLeftCorner.Latitude, b.BottomRightCorner.Longitude, b.TopLeftCorner.Longitude)
-    if err == sql.ErrNoRows {
-        logger.Infof("select * from estate where latitude ...", err)
-        return c.JSON(EstateSearchResponse{Count: 0, Estates: []Estate{}})
-    } else if err != nil {
-        logger.Errorf("database execution error : %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
-    }
-
-    estatesInPolygon := []Estate{}
-    for _, estate := range estatesInBoundingBox {
-        // if polygon contains point, we dont need SQL
-        // ref: https://stackoverflow.com/questions/15618950/check-if-point-is-within-a-polygon
-        p := geo.NewPoint(estate.Latitude, estate.Longitude)
-        polygonPoints := make([]*geo.Point, 0, len(coordinates.Coordinates))
-        for _, co := range coordinates.Coordinates {
-            polygonPoints = append(polygonPoints, geo.NewPoint(co.Latitude, co.Longitude))
-        }
-        polygon := geo.NewPolygon(polygonPoints)
-        if polygon.Contains(p) {
-            estatesInPolygon = append(estatesInPolygon, estate)
-        }
-
-        // validatedEstate := Estate{}
-        // point := fmt.Sprintf("'POINT(%f %f)'", estate.Latitude, estate.Longitude)
-        // query := fmt.Sprintf(`SELECT * FROM estate WHERE id = ? AND ST_Contains(ST_PolygonFromText(%s), ST_GeomFromText(%s))`, coordinates.coordinatesToText(), point)
-        // err = db.Get(&validatedEstate, query, estate.ID)
-        // if err != nil {
-        // 	if err == sql.ErrNoRows {
-        // 		continue
-        // 	} else {
-        // 		logger.Errorf("db access is failed on executing validate if estate is in polygon : %v", err)
-        // 		return c.SendStatus(http.StatusInternalServerError)
-        // 	}
-        // } else {
-        // 	estatesInPolygon = append(estatesInPolygon, validatedEstate)
-        // }
+    estatesInPolygon, err := nazotteSearcher.Search(area, NazotteLimit)
+    if err != nil {
+        logger.Errorf("searchEstateNazotte search error : %v", err)
+        return errInternal
     }
 
-    var re EstateSearchResponse
-    re.Estates = []Estate{}
-    if len(estatesInPolygon) > NazotteLimit {
-        re.Estates = estatesInPolygon[:NazotteLimit]
-    } else {
-        re.Estates = estatesInPolygon
+    if geoJSON {
+        return sendGeoJSON(c, newEstateFeatureCollection(estatesInPolygon))
     }
+
+    var re EstateSearchResponse
+    re.Estates = estatesInPolygon
     re.Count = int64(len(re.Estates))
 
     return c.JSON(re)
 }
 
+// searchEstateNearby estates by distance from lat, lng, within radiusKm and
+// or the k nearest, with the searchEstates filters
+func searchEstateNearby(c *fiber.Ctx) error {
+    lat, err := validateFloat("lat", c.Query("lat"), -90, 90)
+    if err != nil {
+        return err
+    }
+    lng, err := validateFloat("lng", c.Query("lng"), -180, 180)
+    if err != nil {
+        return err
+    }
+
+    if c.Query("radiusKm") == "" && c.Query("k") == "" {
+        return invalid("radiusKm", "radiusKm or k is required")
+    }
+
+    var radiusKm float64
+    if c.Query("radiusKm") != "" {
+        radiusKm, err = validateFloat("radiusKm", c.Query("radiusKm"), 0, maxNearbyKm)
+        if err != nil {
+            return err
+        }
+        if radiusKm == 0 {
+            return invalid("radiusKm", "must be positive")
+        }
+    }
+
+    k := defaultNearbyK
+    if c.Query("k") != "" {
+        k, err = validateInt("k", c.Query("k"), minNearbyK, maxNearbyK)
+        if err != nil {
+            return err
+        }
+    }
+
+    q, _, err := parseEstateFilters(c)
+    if err != nil {
+        return err
+    }
+
+    var res EstateNearbyResponse
+    res.Count, res.Estates = estateGeoIndex.Nearest(lat, lng, radiusKm, k, q.matchEstate)
+
+    return c.JSON(res)
+}
+
+// getEstateClusters estate markers of bbox aggregated for zoom
+func getEstateClusters(c *fiber.Ctx) error {
+    b, err := validateBBox("bbox", c.Query("bbox"))
+    if err != nil {
+        return err
+    }
+    zoom, err := validateInt("zoom", c.Query("zoom"), 0, maxClusterZoom)
+    if err != nil {
+        return err
+    }
+    zoom = clusterZoom(b, zoom)
+
+    return c.JSON(EstateClusterResponse{
+        Zoom:     zoom,
+        Clusters: clusterEstates(b, zoom),
+    })
+}
+
 func postEstateRequestDocument(c *fiber.Ctx) error {
-    params := new(buyParams) 
+    params := new(buyParams)
     if err := c.BodyParser(params); err != nil {
-        logger.Infof("post request document failed : %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
+        return invalid("body", "%s", err)
     }
 
-    if params.Email == "" {
-        logger.Info("post request document failed : email not found in request body")
-        return c.SendStatus(http.StatusBadRequest)
+    if _, err := validateEmail("email", params.Email); err != nil {
+        return err
     }
 
-    id, err := strconv.Atoi(c.Params("id"))
+    id, err := validateID("id", c.Params("id"))
     if err != nil {
-        logger.Infof("post request document failed : %v", err)
-        return c.SendStatus(http.StatusBadRequest)
+        return err
     }
 
     estate := Estate{}
-    query := `SELECT * FROM estate WHERE id = ?`
+    query := "SELECT " + estateSelectColumns + " FROM estate WHERE id = ?"
     err = db.Get(&estate, query, id)
     if err != nil {
         if err == sql.ErrNoRows {
-            return c.SendStatus(http.StatusNotFound)
+            return newAPIError(http.StatusNotFound, "estate_not_found", "estate %d not found", id)
         }
         logger.Errorf("postEstateRequestDocument DB execution error : %v", err)
-        return c.SendStatus(http.StatusInternalServerError)
+        return errInternal
     }
 
     return c.SendStatus(http.StatusOK)
@@ -879,10 +753,47 @@ func (cs Coordinates) getBoundingBox() BoundingBox {
     return boundingBox
 }
 
-func (cs Coordinates) coordinatesToText() string {
+func (cs Coordinates) toPolygon() *geo.Polygon {
+    points := make([]*geo.Point, 0, len(cs.Coordinates))
+    for _, c := range cs.Coordinates {
+        points = append(points, geo.NewPoint(c.Latitude, c.Longitude))
+    }
+    return geo.NewPolygon(points)
+}
+
+// ringText the WKT ring, "(latitude longitude,...)"
+func (cs Coordinates) ringText() string {
     points := make([]string, 0, len(cs.Coordinates))
     for _, c := range cs.Coordinates {
-        points = append(points, fmt.Sprintf("%f %f", c.Latitude, c.Longitude))
+        points = append(points, strconv.FormatFloat(c.Latitude, 'f', -1, 64)+" "+strconv.FormatFloat(c.Longitude, 'f', -1, 64))
+    }
+    return "(" + strings.Join(points, ",") + ")"
+}
+
+func getWriteQueueStats(c *fiber.Ctx) error {
+    return c.JSON(writeQueue.Stats())
+}
+
+func getReconcile(c *fiber.Ctx) error {
+    report, err := reconcile("")
+    if err != nil {
+        logger.Errorf("reconcile err: %s", err)
+        return errInternal
+    }
+    return c.JSON(report)
+}
+
+func postReconcile(c *fiber.Ctx) error {
+    report, err := reconcile(c.Query("winner", reconcileWinner()))
+    if err != nil {
+        switch err {
+        case errReconcileWinner:
+            return invalid("winner", "unknown winner %q", c.Query("winner"))
+        case errReconcilePending:
+            return newAPIError(http.StatusConflict, "reconcile_pending", "%s", err)
+        }
+        logger.Errorf("reconcile err: %s", err)
+        return errInternal
     }
-    return fmt.Sprintf("'POLYGON((%s))'", strings.Join(points, ","))
+    return c.JSON(report)
 }
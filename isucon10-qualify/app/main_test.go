@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	os.Setenv("ENV", "dev")
+	initLogger()
+	cache = newMemoryCache()
+	chairSearchCondition = testChairSearchCondition
+	estateSearchCondition = testEstateSearchCondition
+	os.Exit(m.Run())
+}
+
+// the ranges and lists of the fixture conditions, shortened
+var (
+	testSizeRanges = RangeCondition{Ranges: []*Range{
+		{ID: 0, Min: -1, Max: 80},
+		{ID: 1, Min: 80, Max: 110},
+		{ID: 2, Min: 110, Max: 150},
+		{ID: 3, Min: 150, Max: -1},
+	}}
+	testChairSearchCondition = ChairSearchCondition{
+		Width:  testSizeRanges,
+		Height: testSizeRanges,
+		Depth:  testSizeRanges,
+		Price: RangeCondition{Ranges: []*Range{
+			{ID: 0, Min: -1, Max: 3000},
+			{ID: 1, Min: 3000, Max: 6000},
+			{ID: 2, Min: 6000, Max: 9000},
+			{ID: 3, Min: 9000, Max: -1},
+			{ID: 4, Min: -1, Max: -1},
+		}},
+		Color:   ListCondition{List: []string{"黒", "白", "赤", "青"}},
+		Feature: ListCondition{List: []string{"折りたたみ可", "肘掛け", "キャスター", "リクライニング"}},
+		Kind:    ListCondition{List: []string{"ゲーミングチェア", "座椅子", "エルゴノミクス"}},
+	}
+	testEstateSearchCondition = EstateSearchCondition{
+		DoorWidth:  testSizeRanges,
+		DoorHeight: testSizeRanges,
+		Rent: RangeCondition{Ranges: []*Range{
+			{ID: 0, Min: -1, Max: 50000},
+			{ID: 1, Min: 50000, Max: 100000},
+			{ID: 2, Min: 100000, Max: -1},
+		}},
+		Feature: ListCondition{List: []string{"最上階", "防犯カメラ", "ワンルーム"}},
+	}
+	testTexts = []string{"ふかふか", "ＧＡＭＩＮＧ", "gaming", "Office", "ワークチェア", "50%オフ"}
+)
+
+// testChairs n chairs spread over every condition, the same for a seed
+func testChairs(seed int64, n int) []*Chair {
+	r := rand.New(rand.NewSource(seed))
+	cond := testChairSearchCondition
+	chairs := make([]*Chair, 0, n)
+	for i := 1; i <= n; i++ {
+		features := make([]string, 0)
+		for _, f := range cond.Feature.List {
+			if r.Intn(3) == 0 {
+				features = append(features, f)
+			}
+		}
+		chairs = append(chairs, &Chair{
+			ID:          int64(i),
+			Name:        fmt.Sprintf("chair %d %s", i, testTexts[r.Intn(len(testTexts))]),
+			Description: testTexts[r.Intn(len(testTexts))],
+			Price:       int64(r.Intn(12000)),
+			Height:      int64(50 + r.Intn(150)),
+			Width:       int64(50 + r.Intn(150)),
+			Depth:       int64(50 + r.Intn(150)),
+			Color:       cond.Color.List[r.Intn(len(cond.Color.List))],
+			Features:    strings.Join(features, ","),
+			Kind:        cond.Kind.List[r.Intn(len(cond.Kind.List))],
+			Popularity:  int64(r.Intn(50)),
+			Stock:       int64(r.Intn(3)),
+		})
+	}
+	return chairs
+}
+
+func testEstates(seed int64, n int) []*Estate {
+	r := rand.New(rand.NewSource(seed))
+	cond := testEstateSearchCondition
+	estates := make([]*Estate, 0, n)
+	for i := 1; i <= n; i++ {
+		features := make([]string, 0)
+		for _, f := range cond.Feature.List {
+			if r.Intn(3) == 0 {
+				features = append(features, f)
+			}
+		}
+		estates = append(estates, &Estate{
+			ID:          int64(i),
+			Name:        fmt.Sprintf("estate %d %s", i, testTexts[r.Intn(len(testTexts))]),
+			Description: testTexts[r.Intn(len(testTexts))],
+			Latitude:    35 + r.Float64(),
+			Longitude:   139 + r.Float64(),
+			Rent:        int64(r.Intn(150000)),
+			DoorHeight:  int64(50 + r.Intn(150)),
+			DoorWidth:   int64(50 + r.Intn(150)),
+			Features:    strings.Join(features, ","),
+			Popularity:  int64(r.Intn(50)),
+		})
+	}
+	return estates
+}
+
+// loadTestTables replaces every cached row and index with the given ones
+func loadTestTables(t *testing.T, chairs []*Chair, estates []*Estate) {
+	t.Helper()
+	if err := cache.FlushAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	cacheTables(chairs, estates)
+}
+
+// sqlRange evaluates the condition rangeConditionSQL builds for a row whose
+// column holds v
+func sqlRange(t *testing.T, column string, cond RangeCondition, mask uint64, v int64) bool {
+	where, params := rangeConditionSQL(column, cond, mask)
+	if where == "" {
+		return true
+	}
+	match := false
+	for _, or := range strings.Split(strings.Trim(where, "()"), " OR ") {
+		ok := true
+		for _, and := range strings.Split(or, " AND ") {
+			p := params[0].(int64)
+			params = params[1:]
+			switch and {
+			case column + " >= ?":
+				ok = ok && v >= p
+			case column + " < ?":
+				ok = ok && v < p
+			default:
+				t.Fatalf("unexpected condition %q", and)
+			}
+		}
+		match = match || ok
+	}
+	return match
+}
+
+// sqlList evaluates the condition listConditionSQL builds
+func sqlList(t *testing.T, column string, values listFilter, v string) bool {
+	where, params := listConditionSQL(column, values)
+	if where == "" {
+		return true
+	}
+	if !strings.HasPrefix(where, column+" IN (") {
+		t.Fatalf("unexpected condition %q", where)
+	}
+	for _, p := range params {
+		if p.(string) == v {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlText a LIKE of every term over name and description as normalizeText
+// folds them
+func sqlText(m *TextMatch, name, description string) bool {
+	if m == nil {
+		return true
+	}
+	text := normalizeText(name + "\n" + description)
+	for _, term := range m.Terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// sqlTextIDs evaluates the condition textConditionSQL builds for the row id
+func sqlTextIDs(t *testing.T, m *TextMatch, id int64) bool {
+	where, params := textConditionSQL(m)
+	switch where {
+	case "":
+		return true
+	case "FALSE":
+		return false
+	}
+	if where != "id IN (?"+strings.Repeat(", ?", len(params)-1)+")" {
+		t.Fatalf("unexpected text condition %q", where)
+	}
+	for _, p := range params {
+		if p.(int64) == id {
+			return true
+		}
+	}
+	return false
+}
+
+func sqlFeatures(list []string, f featureFilter, features string) bool {
+	for _, feature := range f.known(list) {
+		if !strings.Contains(features, feature) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustMask(t *testing.T, cond RangeCondition, ids string) uint64 {
+	if ids == "" {
+		return 0
+	}
+	mask, err := getRangeMask(cond, ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mask
+}
+
+func mustList(t *testing.T, cond ListCondition, values string) listFilter {
+	if values == "" {
+		return nil
+	}
+	f, err := getListFilter(cond, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func mustFeatures(t *testing.T, cond ListCondition, values string) featureFilter {
+	if values == "" {
+		return featureFilter{}
+	}
+	f, err := validateFeatures("features", cond, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func mustText(t *testing.T, ti *TextIndex, q string) *TextMatch {
+	if q == "" {
+		return nil
+	}
+	terms, err := parseTextQuery(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ti.Match(terms)
+}
+
+func chairIDs(chairs []Chair) []int64 {
+	ids := make([]int64, 0, len(chairs))
+	for _, c := range chairs {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+func estateIDs(estates []Estate) []int64 {
+	ids := make([]int64, 0, len(estates))
+	for _, e := range estates {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestChairSearchersMatchSQL(t *testing.T) {
+	chairs := testChairs(1, 400)
+	loadTestTables(t, chairs, nil)
+	cond := testChairSearchCondition
+
+	tests := []struct {
+		name     string
+		price    string
+		height   string
+		width    string
+		depth    string
+		kind     string
+		color    string
+		features string
+		q        string
+		sort     string
+	}{
+		{name: "price", price: "1"},
+		{name: "price unbounded", price: "4"},
+		{name: "price ranges and color", price: "0,3", color: "黒"},
+		{name: "sizes", height: "1", width: "2,3", depth: "0"},
+		{name: "kinds", kind: "座椅子,エルゴノミクス"},
+		{name: "colors", color: "白,赤,青"},
+		{name: "features", features: "肘掛け,キャスター"},
+		{name: "text", q: "gaming"},
+		{name: "full width text", q: "ＧＡＭＩＮＧ ふかふか"},
+		{name: "like wildcard", q: "50%オフ"},
+		{name: "one rune", q: "ふ"},
+		{name: "one rune and a bigram", q: "% オフ"},
+		{name: "price desc", price: "1,2", sort: "price_desc"},
+		{name: "size asc", kind: "ゲーミングチェア", sort: "size_asc"},
+		{name: "newest", color: "黒", sort: "newest"},
+	}
+	searchers := []struct {
+		name     string
+		searcher ChairSearcher
+	}{
+		{"index", indexChairSearcher{}},
+		{"cache", cacheChairSearcher{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := getChairSort(tt.sort)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q := ChairQuery{
+				Price:    mustMask(t, cond.Price, tt.price),
+				Height:   mustMask(t, cond.Height, tt.height),
+				Width:    mustMask(t, cond.Width, tt.width),
+				Depth:    mustMask(t, cond.Depth, tt.depth),
+				Kind:     mustList(t, cond.Kind, tt.kind),
+				Color:    mustList(t, cond.Color, tt.color),
+				Features: mustFeatures(t, cond.Feature, tt.features),
+				Text:     mustText(t, chairTextIndex, tt.q),
+				Sort:     order,
+			}
+
+			want := make([]*Chair, 0)
+			for _, c := range chairs {
+				if c.Stock > 0 &&
+					sqlRange(t, "price", cond.Price, q.Price, c.Price) &&
+					sqlRange(t, "height", cond.Height, q.Height, c.Height) &&
+					sqlRange(t, "width", cond.Width, q.Width, c.Width) &&
+					sqlRange(t, "depth", cond.Depth, q.Depth, c.Depth) &&
+					sqlList(t, "kind", q.Kind, c.Kind) &&
+					sqlList(t, "color", q.Color, c.Color) &&
+					sqlFeatures(cond.Feature.List, q.Features, c.Features) &&
+					sqlTextIDs(t, q.Text, c.ID) {
+					want = append(want, c)
+				}
+				if c.Stock > 0 && sqlTextIDs(t, q.Text, c.ID) != sqlText(q.Text, c.Name, c.Description) {
+					t.Fatalf("chair %d: the text index and the LIKE of %q disagree", c.ID, tt.q)
+				}
+			}
+			sort.Slice(want, func(i, j int) bool { return order.less(want[i], want[j]) })
+			if len(want) == 0 {
+				t.Fatal("no chair matches, the case tests nothing")
+			}
+			wantIDs := make([]int64, 0, len(want))
+			for _, c := range want {
+				wantIDs = append(wantIDs, c.ID)
+			}
+
+			for _, s := range searchers {
+				count, got, err := s.searcher.Search(&q, 0, len(chairs))
+				if err != nil {
+					t.Fatalf("%s: %v", s.name, err)
+				}
+				if count != int64(len(want)) {
+					t.Errorf("%s: count = %d, want %d", s.name, count, len(want))
+				}
+				if gotIDs := chairIDs(got); fmt.Sprint(gotIDs) != fmt.Sprint(wantIDs) {
+					t.Errorf("%s: ids = %v, want %v", s.name, gotIDs, wantIDs)
+				}
+			}
+		})
+	}
+}
+
+func TestEstateIndexMatchesSQL(t *testing.T) {
+	estates := testEstates(2, 400)
+	loadTestTables(t, nil, estates)
+	cond := testEstateSearchCondition
+
+	tests := []struct {
+		name       string
+		rent       string
+		doorHeight string
+		doorWidth  string
+		features   string
+		q          string
+		sort       string
+	}{
+		{name: "rent", rent: "1"},
+		{name: "rents", rent: "0,2"},
+		{name: "door", doorHeight: "2,3", doorWidth: "1"},
+		{name: "features", features: "最上階,防犯カメラ"},
+		{name: "text", q: "ワークチェア"},
+		{name: "rent desc", rent: "0", sort: "rent_desc"},
+		{name: "size desc", doorWidth: "3", sort: "size_desc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := getEstateSort(tt.sort)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q := EstateQuery{
+				Rent:       mustMask(t, cond.Rent, tt.rent),
+				DoorHeight: mustMask(t, cond.DoorHeight, tt.doorHeight),
+				DoorWidth:  mustMask(t, cond.DoorWidth, tt.doorWidth),
+				Features:   mustFeatures(t, cond.Feature, tt.features),
+				Text:       mustText(t, estateTextIndex, tt.q),
+				Sort:       order,
+			}
+
+			want := make([]*Estate, 0)
+			for _, e := range estates {
+				if sqlRange(t, "rent", cond.Rent, q.Rent, e.Rent) &&
+					sqlRange(t, "door_height", cond.DoorHeight, q.DoorHeight, e.DoorHeight) &&
+					sqlRange(t, "door_width", cond.DoorWidth, q.DoorWidth, e.DoorWidth) &&
+					sqlFeatures(cond.Feature.List, q.Features, e.Features) &&
+					sqlText(q.Text, e.Name, e.Description) {
+					want = append(want, e)
+				}
+			}
+			sort.Slice(want, func(i, j int) bool { return order.less(want[i], want[j]) })
+			if len(want) == 0 {
+				t.Fatal("no estate matches, the case tests nothing")
+			}
+			wantIDs := make([]int64, 0, len(want))
+			for _, e := range want {
+				wantIDs = append(wantIDs, e.ID)
+			}
+
+			count, got := estateIndex.Search(&q, 0, len(estates))
+			if count != int64(len(want)) {
+				t.Errorf("count = %d, want %d", count, len(want))
+			}
+			if gotIDs := estateIDs(got); fmt.Sprint(gotIDs) != fmt.Sprint(wantIDs) {
+				t.Errorf("ids = %v, want %v", gotIDs, wantIDs)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// nazotte search backends, picked with NAZOTTE_SEARCH
+const (
+	nazotteSearchIndex = "index"
+	nazotteSearchSQL   = "sql"
+)
+
+var nazotteSearcher NazotteSearcher
+
+// NazotteSearcher answers searchEstateNazotte, the first limit estates inside
+// the area in popularity_desc, id order
+type NazotteSearcher interface {
+	Search(area NazotteArea, limit int) ([]Estate, error)
+}
+
+// initNazotteSearcher picks the backend. They differ at the edges: ST_Contains
+// leaves out estates on the boundary of a ring, golang-geo's Polygon.Contains
+// decides boundary points by its ray casting, so an estate exactly on an edge
+// or vertex may be in one answer and not the other.
+func initNazotteSearcher() {
+	backend := getEnv("NAZOTTE_SEARCH", nazotteSearchIndex)
+	switch backend {
+	case nazotteSearchIndex:
+		nazotteSearcher = indexNazotteSearcher{}
+	case nazotteSearchSQL:
+		nazotteSearcher = sqlNazotteSearcher{}
+	default:
+		logger.Fatalf("unknown NAZOTTE_SEARCH: %s", backend)
+	}
+	logger.Infof("nazotte search backend: %s", backend)
+}
+
+// indexNazotteSearcher the bounding box from estateGeoIndex, then the
+// polygon test in Go
+type indexNazotteSearcher struct{}
+
+func (indexNazotteSearcher) Search(area NazotteArea, limit int) ([]Estate, error) {
+	b := area.getBoundingBox()
+	contains := area.contains()
+	estates := make([]Estate, 0, limit)
+	estateGeoIndex.Within(b, func(estate *Estate) bool {
+		// if polygon contains point, we dont need SQL
+		// ref: https://stackoverflow.com/questions/15618950/check-if-point-is-within-a-polygon
+		if contains(estate.Latitude, estate.Longitude) {
+			estates = append(estates, *estate)
+		}
+		return len(estates) < limit
+	})
+	return estates, nil
+}
+
+// sqlNazotteSearcher one query over the SPATIAL index of estate.point,
+// ST_Contains on a constant geometry is answered from the index
+type sqlNazotteSearcher struct{}
+
+func (sqlNazotteSearcher) Search(area NazotteArea, limit int) ([]Estate, error) {
+	query := fmt.Sprintf("SELECT %s FROM estate WHERE ST_Contains(ST_GeomFromText(%s), point) ORDER BY popularity_desc, id LIMIT ?",
+		estateSelectColumns, area.toText())
+	estates := make([]Estate, 0, limit)
+	if err := db.Select(&estates, query, limit); err != nil {
+		return nil, err
+	}
+	return estates, nil
+}
+
+// toText the area as quoted WKT in the POINT(latitude longitude) order of
+// estate.point, a MULTIPOLYGON when there are several polygons
+func (a NazotteArea) toText() string {
+	polygons := make([]string, 0, len(a.Polygons))
+	for _, p := range a.Polygons {
+		rings := []string{p.Outer.ringText()}
+		for _, h := range p.Holes {
+			rings = append(rings, h.ringText())
+		}
+		polygons = append(polygons, "("+strings.Join(rings, ",")+")")
+	}
+	if len(polygons) == 1 {
+		return "'POLYGON" + polygons[0] + "'"
+	}
+	return "'MULTIPOLYGON(" + strings.Join(polygons, ",") + ")'"
+}
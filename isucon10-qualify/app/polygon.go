@@ -0,0 +1,264 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// nazotte ring limits, rings above maxRingVertices are simplified within
+// ringTolerance degrees (about 1m) and rejected if still above it or if the
+// simplification makes them cross themselves.
+const (
+	maxRingInput    = 10000
+	maxRingVertices = 500
+	ringTolerance   = 0.00001
+)
+
+// normalize validates every ring of the area, closes open rings and
+// simplifies the large ones
+func (a *NazotteArea) normalize() error {
+	for i := range a.Polygons {
+		p := &a.Polygons[i]
+		outer, err := normalizeRing(p.Outer.Coordinates)
+		if err != nil {
+			return err
+		}
+		p.Outer.Coordinates = outer
+		for j := range p.Holes {
+			hole, err := normalizeRing(p.Holes[j].Coordinates)
+			if err != nil {
+				return err
+			}
+			if !ringWithin(hole, outer) {
+				return invalid("coordinates", "hole is not inside its outer ring")
+			}
+			p.Holes[j].Coordinates = hole
+		}
+	}
+	return nil
+}
+
+// normalizeRing the ring closed, first == last, without repeated points
+func normalizeRing(ring []Coordinate) ([]Coordinate, error) {
+	if len(ring) > maxRingInput {
+		return nil, invalid("coordinates", "ring has more than %d points", maxRingInput)
+	}
+
+	cs := make([]Coordinate, 0, len(ring)+1)
+	for _, c := range ring {
+		if math.IsNaN(c.Latitude) || c.Latitude < -90 || c.Latitude > 90 ||
+			math.IsNaN(c.Longitude) || c.Longitude < -180 || c.Longitude > 180 {
+			return nil, invalid("coordinates", "point (%g, %g) is out of range", c.Latitude, c.Longitude)
+		}
+		if len(cs) > 0 && cs[len(cs)-1] == c {
+			continue
+		}
+		cs = append(cs, c)
+	}
+	if len(cs) > 1 && cs[0] == cs[len(cs)-1] {
+		cs = cs[:len(cs)-1]
+	}
+	if len(cs) < 3 {
+		return nil, invalid("coordinates", "ring needs at least 3 distinct points")
+	}
+	cs = append(cs, cs[0])
+
+	for i := 1; i < len(cs); i++ {
+		if math.Abs(cs[i].Longitude-cs[i-1].Longitude) > 180 {
+			return nil, invalid("coordinates", "ring crosses the antimeridian")
+		}
+	}
+
+	if ringSelfIntersects(cs) {
+		return nil, invalid("coordinates", "ring intersects itself")
+	}
+	if ringArea(cs) == 0 {
+		return nil, invalid("coordinates", "ring has no area")
+	}
+
+	if len(cs)-1 > maxRingVertices {
+		simplified := simplifyRing(cs, ringTolerance)
+		if len(simplified)-1 > maxRingVertices {
+			return nil, invalid("coordinates", "ring has more than %d vertices after simplification", maxRingVertices)
+		}
+		// Douglas-Peucker does not keep the topology, a ring it folds
+		// cannot be brought under the cap
+		if len(simplified) < 4 || ringSelfIntersects(simplified) || ringArea(simplified) == 0 {
+			return nil, invalid("coordinates", "ring has more than %d vertices and cannot be simplified", maxRingVertices)
+		}
+		cs = simplified
+	}
+	return cs, nil
+}
+
+// simplifyRing Douglas-Peucker over a closed ring, split at the point
+// farthest from the first so both halves have distinct ends
+func simplifyRing(cs []Coordinate, tolerance float64) []Coordinate {
+	far, farDist := 0, -1.0
+	for i, c := range cs {
+		d := math.Hypot(c.Latitude-cs[0].Latitude, c.Longitude-cs[0].Longitude)
+		if d > farDist {
+			far, farDist = i, d
+		}
+	}
+	a := simplifyLine(cs[:far+1], tolerance)
+	b := simplifyLine(cs[far:], tolerance)
+	return append(a, b[1:]...)
+}
+
+func simplifyLine(cs []Coordinate, tolerance float64) []Coordinate {
+	if len(cs) < 3 {
+		return append([]Coordinate{}, cs...)
+	}
+	first, last := cs[0], cs[len(cs)-1]
+	max, maxDist := 0, -1.0
+	for i := 1; i < len(cs)-1; i++ {
+		d := segmentDistance(cs[i], first, last)
+		if d > maxDist {
+			max, maxDist = i, d
+		}
+	}
+	if maxDist <= tolerance {
+		return []Coordinate{first, last}
+	}
+	a := simplifyLine(cs[:max+1], tolerance)
+	b := simplifyLine(cs[max:], tolerance)
+	return append(a, b[1:]...)
+}
+
+// segmentDistance distance in degrees from p to the segment a-b
+func segmentDistance(p, a, b Coordinate) float64 {
+	dx, dy := b.Longitude-a.Longitude, b.Latitude-a.Latitude
+	if dx == 0 && dy == 0 {
+		return math.Hypot(p.Longitude-a.Longitude, p.Latitude-a.Latitude)
+	}
+	t := ((p.Longitude-a.Longitude)*dx + (p.Latitude-a.Latitude)*dy) / (dx*dx + dy*dy)
+	t = math.Max(0, math.Min(1, t))
+	return math.Hypot(p.Longitude-(a.Longitude+t*dx), p.Latitude-(a.Latitude+t*dy))
+}
+
+// ringArea the shoelace area of a closed ring in square degrees
+func ringArea(cs []Coordinate) float64 {
+	var sum float64
+	for i := 1; i < len(cs); i++ {
+		sum += cs[i-1].Longitude*cs[i].Latitude - cs[i].Longitude*cs[i-1].Latitude
+	}
+	return math.Abs(sum) / 2
+}
+
+// ringSelfIntersects whether two edges of a closed ring touch other than
+// neighbours at their shared vertex
+func ringSelfIntersects(cs []Coordinate) bool {
+	n := len(cs) - 1
+	for i := 0; i < n; i++ {
+		// the edge i-1, i and its neighbour i, i+1
+		prev := cs[(i+n-1)%n]
+		if foldsBack(prev, cs[i], cs[i+1]) {
+			return true
+		}
+	}
+
+	// sweep the edges west to east, only edges overlapping in longitude can
+	// touch, edge i is cs[i] to cs[i+1]
+	west := func(i int) float64 { return math.Min(cs[i].Longitude, cs[i+1].Longitude) }
+	east := func(i int) float64 { return math.Max(cs[i].Longitude, cs[i+1].Longitude) }
+	edges := make([]int, n)
+	for i := range edges {
+		edges[i] = i
+	}
+	sort.Slice(edges, func(a, b int) bool { return west(edges[a]) < west(edges[b]) })
+
+	active := make([]int, 0)
+	for _, i := range edges {
+		kept := active[:0]
+		for _, j := range active {
+			if east(j) < west(i) {
+				continue
+			}
+			kept = append(kept, j)
+			if d := i - j; d == 1 || d == -1 || d == n-1 || d == 1-n {
+				continue
+			}
+			if segmentsIntersect(cs[i], cs[i+1], cs[j], cs[j+1]) {
+				return true
+			}
+		}
+		active = append(kept, i)
+	}
+	return false
+}
+
+// ringWithin whether the closed ring inner lies inside outer, every vertex
+// inside and no edges crossing
+func ringWithin(inner, outer []Coordinate) bool {
+	for _, c := range inner[:len(inner)-1] {
+		if !pointInRing(c, outer) {
+			return false
+		}
+	}
+	for i := 1; i < len(inner); i++ {
+		for j := 1; j < len(outer); j++ {
+			if segmentsCross(inner[i-1], inner[i], outer[j-1], outer[j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// pointInRing even-odd ray casting towards the east
+func pointInRing(p Coordinate, cs []Coordinate) bool {
+	in := false
+	for i := 1; i < len(cs); i++ {
+		a, b := cs[i-1], cs[i]
+		if (a.Latitude > p.Latitude) == (b.Latitude > p.Latitude) {
+			continue
+		}
+		lng := a.Longitude + (p.Latitude-a.Latitude)/(b.Latitude-a.Latitude)*(b.Longitude-a.Longitude)
+		if p.Longitude < lng {
+			in = !in
+		}
+	}
+	return in
+}
+
+// foldsBack whether the edges a-s and s-b overlap, i.e. the ring turns back
+// on itself at s
+func foldsBack(a, s, b Coordinate) bool {
+	return orientation(a, s, b) == 0 && (onSegment(s, a, b) || onSegment(s, b, a))
+}
+
+func orientation(a, b, c Coordinate) int {
+	v := (b.Longitude-a.Longitude)*(c.Latitude-a.Latitude) - (b.Latitude-a.Latitude)*(c.Longitude-a.Longitude)
+	switch {
+	case v > 0:
+		return 1
+	case v < 0:
+		return -1
+	}
+	return 0
+}
+
+// onSegment whether p, collinear with a-b, lies within its box
+func onSegment(a, b, p Coordinate) bool {
+	return math.Min(a.Longitude, b.Longitude) <= p.Longitude && p.Longitude <= math.Max(a.Longitude, b.Longitude) &&
+		math.Min(a.Latitude, b.Latitude) <= p.Latitude && p.Latitude <= math.Max(a.Latitude, b.Latitude)
+}
+
+// segmentsCross whether the segments cross at a point inside both
+func segmentsCross(p1, p2, q1, q2 Coordinate) bool {
+	return orientation(p1, p2, q1)*orientation(p1, p2, q2) < 0 &&
+		orientation(q1, q2, p1)*orientation(q1, q2, p2) < 0
+}
+
+func segmentsIntersect(p1, p2, q1, q2 Coordinate) bool {
+	o1, o2 := orientation(p1, p2, q1), orientation(p1, p2, q2)
+	o3, o4 := orientation(q1, q2, p1), orientation(q1, q2, p2)
+	if o1 != o2 && o3 != o4 {
+		return true
+	}
+	return o1 == 0 && onSegment(p1, p2, q1) ||
+		o2 == 0 && onSegment(p1, p2, q2) ||
+		o3 == 0 && onSegment(q1, q2, p1) ||
+		o4 == 0 && onSegment(q1, q2, p2)
+}
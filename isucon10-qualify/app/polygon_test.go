@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// ring a ring from latitude, longitude pairs
+func ring(points ...[2]float64) []Coordinate {
+	cs := make([]Coordinate, 0, len(points))
+	for _, p := range points {
+		cs = append(cs, Coordinate{Latitude: p[0], Longitude: p[1]})
+	}
+	return cs
+}
+
+// circle an open ring of n points around lat, lng
+func circle(lat, lng, radius float64, n int) []Coordinate {
+	cs := make([]Coordinate, 0, n)
+	for i := 0; i < n; i++ {
+		a := 2 * math.Pi * float64(i) / float64(n)
+		cs = append(cs, Coordinate{Latitude: lat + radius*math.Sin(a), Longitude: lng + radius*math.Cos(a)})
+	}
+	return cs
+}
+
+// sliver an open ring of n points along each side of a strip thinner than
+// ringTolerance, the simplifier collapses it to a line
+func sliver(n int) []Coordinate {
+	cs := make([]Coordinate, 0, 2*n)
+	for i := 0; i < n; i++ {
+		cs = append(cs, Coordinate{Latitude: 35, Longitude: 139 + float64(i)/float64(n)})
+	}
+	for i := n; i > 0; i-- {
+		cs = append(cs, Coordinate{Latitude: 35 + ringTolerance/2, Longitude: 139 + float64(i)/float64(n)})
+	}
+	return cs
+}
+
+func TestNormalizeRing(t *testing.T) {
+	square := ring([2]float64{35, 139}, [2]float64{35, 140}, [2]float64{36, 140}, [2]float64{36, 139})
+	tests := []struct {
+		name    string
+		ring    []Coordinate
+		wantLen int
+		wantErr string
+	}{
+		{"open", square, 5, ""},
+		{"closed", append(square, square[0]), 5, ""},
+		{"repeated points", append([]Coordinate{square[0], square[0]}, square[1:]...), 5, ""},
+		{"too few points", ring([2]float64{35, 139}, [2]float64{35, 140}, [2]float64{35, 139}), 0, "at least 3"},
+		{"out of range", ring([2]float64{35, 139}, [2]float64{91, 140}, [2]float64{36, 140}), 0, "out of range"},
+		{"nan", ring([2]float64{35, 139}, [2]float64{math.NaN(), 140}, [2]float64{36, 140}), 0, "out of range"},
+		{"antimeridian", ring([2]float64{35, 179}, [2]float64{35, -179}, [2]float64{36, -179}), 0, "antimeridian"},
+		{"bowtie", ring([2]float64{35, 139}, [2]float64{36, 140}, [2]float64{35, 140}, [2]float64{36, 139}), 0, "intersects itself"},
+		{"folds back", ring([2]float64{35, 139}, [2]float64{35, 141}, [2]float64{35, 140}, [2]float64{36, 140}), 0, "intersects itself"},
+		{"collinear", ring([2]float64{35, 139}, [2]float64{35, 140}, [2]float64{35, 141}), 0, "intersects itself"},
+		{"too many points", circle(35, 139, 0.01, maxRingInput+1), 0, "more than"},
+		{"small vertices", circle(35, 139, 0.01, 400), 401, ""},
+		{"simplified", circle(35, 139, 0.01, 1000), -1, ""},
+		{"not simplified enough", circle(35, 139, 10, 1000), 0, "after simplification"},
+		{"thin under the cap", sliver(200), 401, ""},
+		{"simplifier folds", sliver(300), 0, "cannot be simplified"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeRing(tt.ring)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got[0] != got[len(got)-1] {
+				t.Errorf("ring is not closed")
+			}
+			if tt.wantLen < 0 {
+				if len(got)-1 > maxRingVertices || len(got) >= len(tt.ring) {
+					t.Errorf("simplified to %d points", len(got))
+				}
+			} else if len(got) != tt.wantLen {
+				t.Errorf("len = %d, want %d", len(got), tt.wantLen)
+			}
+			if ringSelfIntersects(got) {
+				t.Errorf("normalized ring intersects itself")
+			}
+		})
+	}
+}
+
+func TestRingSelfIntersects(t *testing.T) {
+	crossed := append(circle(35, 139, 1, 1000), Coordinate{})
+	crossed[250], crossed[750] = crossed[750], crossed[250]
+	crossed[len(crossed)-1] = crossed[0]
+
+	tests := []struct {
+		name string
+		ring []Coordinate
+		want bool
+	}{
+		{"square", ring([2]float64{0, 0}, [2]float64{0, 1}, [2]float64{1, 1}, [2]float64{1, 0}, [2]float64{0, 0}), false},
+		{"concave", ring([2]float64{0, 0}, [2]float64{0, 2}, [2]float64{1, 2}, [2]float64{1, 1}, [2]float64{2, 1}, [2]float64{2, 0}, [2]float64{0, 0}), false},
+		{"straight vertex", ring([2]float64{0, 0}, [2]float64{0, 1}, [2]float64{0, 2}, [2]float64{1, 2}, [2]float64{0, 0}), false},
+		{"bowtie", ring([2]float64{0, 0}, [2]float64{1, 1}, [2]float64{0, 1}, [2]float64{1, 0}, [2]float64{0, 0}), true},
+		{"folds back", ring([2]float64{0, 0}, [2]float64{0, 2}, [2]float64{0, 1}, [2]float64{1, 1}, [2]float64{0, 0}), true},
+		{"folds back at the start", ring([2]float64{0, 1}, [2]float64{1, 1}, [2]float64{0, 0}, [2]float64{0, 2}, [2]float64{0, 1}), true},
+		{"shared vertex", ring([2]float64{0, 0}, [2]float64{1, 1}, [2]float64{0, 2}, [2]float64{2, 2}, [2]float64{1, 1}, [2]float64{2, 0}, [2]float64{0, 0}), true},
+		{"touches an edge", ring([2]float64{0, 0}, [2]float64{0, 2}, [2]float64{2, 2}, [2]float64{0, 1}, [2]float64{2, 0}, [2]float64{0, 0}), true},
+		{"circle", append(circle(35, 139, 1, 1000), circle(35, 139, 1, 1)...), false},
+		{"crossed circle", crossed, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ringSelfIntersects(tt.ring); got != tt.want {
+				t.Errorf("ringSelfIntersects = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNazotteAreaNormalizeHoles(t *testing.T) {
+	square := ring([2]float64{35, 139}, [2]float64{35, 140}, [2]float64{36, 140}, [2]float64{36, 139})
+	notched := ring([2]float64{35, 139}, [2]float64{35, 139.4}, [2]float64{35.5, 139.5}, [2]float64{35, 139.6},
+		[2]float64{35, 140}, [2]float64{36, 140}, [2]float64{36, 139})
+	tests := []struct {
+		name    string
+		outer   []Coordinate
+		hole    []Coordinate
+		wantErr bool
+	}{
+		{"inside", square, ring([2]float64{35.2, 139.2}, [2]float64{35.2, 139.8}, [2]float64{35.8, 139.8}, [2]float64{35.8, 139.2}), false},
+		{"outside", square, ring([2]float64{37, 139}, [2]float64{37, 140}, [2]float64{38, 140}), true},
+		{"crossing", square, ring([2]float64{35.5, 139.5}, [2]float64{35.5, 141}, [2]float64{35.8, 141}, [2]float64{35.8, 139.5}), true},
+		{"around", square, ring([2]float64{34, 138}, [2]float64{34, 141}, [2]float64{37, 141}, [2]float64{37, 138}), true},
+		// every vertex inside, an edge leaves through the notch
+		{"across a notch", notched, ring([2]float64{35.1, 139.1}, [2]float64{35.1, 139.9}, [2]float64{35.8, 139.5}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NazotteArea{Polygons: []NazottePolygon{{
+				Outer: Coordinates{Coordinates: tt.outer},
+				Holes: []Coordinates{{Coordinates: tt.hole}},
+			}}}
+			err := a.normalize()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "hole") {
+				t.Errorf("err = %v, want the hole rejected", err)
+			}
+		})
+	}
+}
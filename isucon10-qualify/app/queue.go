@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// write ops
+const (
+	writeOpBuyChair      = "buy_chair"
+	writeOpInsertChairs  = "insert_chairs"
+	writeOpInsertEstates = "insert_estates"
+)
+
+const (
+	writeQueueMaxAttempts = 5
+	writeQueueRetryDelay  = 100 * time.Millisecond
+)
+
+var writeQueue *WriteQueue
+
+// writeJob a pending MySQL mutation, journaled as one JSON line.
+// A later line with the same Seq and Done or Failed set resolves it.
+// ID is unique across journals and restarts, applyWriteJob records it in
+// applied_jobs with the mutation so a replay never applies it twice.
+type writeJob struct {
+	Seq     int64      `json:"seq"`
+	ID      string     `json:"id,omitempty"`
+	Op      string     `json:"op,omitempty"`
+	ChairID int64      `json:"chairId,omitempty"`
+	Records [][]string `json:"records,omitempty"`
+	Done    bool       `json:"done,omitempty"`
+	Failed  string     `json:"failed,omitempty"`
+}
+
+type WriteQueueStats struct {
+	Pending int `json:"pending"`
+	Failed  int `json:"failed"`
+}
+
+// WriteQueue write-behind queue backed by an append-only journal, jobs are
+// written to the file before the handler answers and replayed into MySQL by
+// run, so a restart resumes whatever was left pending.
+type WriteQueue struct {
+	path string
+
+	mu      sync.Mutex
+	file    *os.File
+	seq     int64
+	pending []*writeJob
+	failed  []*writeJob
+	notify  chan struct{}
+
+	// applyMu is held while a job touches MySQL, so Reset never races a write
+	applyMu sync.Mutex
+
+	// gate is held shared by the handlers from their cache write to Enqueue
+	// and exclusively by a repairing reconcile, see Enter and Pause
+	gate sync.RWMutex
+}
+
+func openWriteQueue(path string) (*WriteQueue, error) {
+	q := &WriteQueue{
+		path:   path,
+		notify: make(chan struct{}, 1),
+	}
+	if err := q.replay(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	q.file = f
+	if len(q.pending) > 0 {
+		logger.Infof("write queue replaying %d pending jobs", len(q.pending))
+		q.wake()
+	}
+	return q, nil
+}
+
+func (q *WriteQueue) replay() error {
+	f, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	jobs := make(map[int64]*writeJob)
+	order := make([]int64, 0)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for sc.Scan() {
+		job := &writeJob{}
+		if err := json.Unmarshal(sc.Bytes(), job); err != nil {
+			// torn tail of a crashed write, nothing after it was acknowledged
+			logger.Errorf("write queue skip broken line: %v", err)
+			continue
+		}
+		if job.Seq > q.seq {
+			q.seq = job.Seq
+		}
+		switch {
+		case job.Done:
+			delete(jobs, job.Seq)
+		case job.Failed != "":
+			if j, ok := jobs[job.Seq]; ok {
+				j.Failed = job.Failed
+			} else {
+				jobs[job.Seq] = job
+				order = append(order, job.Seq)
+			}
+		default:
+			if job.ID == "" {
+				// journaled before jobs had ids, no replay guard
+				job.ID = newWriteJobID()
+			}
+			jobs[job.Seq] = job
+			order = append(order, job.Seq)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	for _, seq := range order {
+		job, ok := jobs[seq]
+		if !ok {
+			continue
+		}
+		if job.Failed != "" {
+			q.failed = append(q.failed, job)
+		} else {
+			q.pending = append(q.pending, job)
+		}
+	}
+	return nil
+}
+
+func (q *WriteQueue) append(job *writeJob) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if _, err := q.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return q.file.Sync()
+}
+
+func (q *WriteQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue journals the job, it returns once the job is durable
+func (q *WriteQueue) Enqueue(job *writeJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	job.Seq = q.seq
+	job.ID = newWriteJobID()
+	if err := q.append(job); err != nil {
+		return err
+	}
+	q.pending = append(q.pending, job)
+	q.wake()
+	return nil
+}
+
+// Enter holds off Pause until the returned func is called. A handler holds
+// it from its first cache write to Enqueue, so a repairing reconcile never
+// sees the cache and the queue half written.
+func (q *WriteQueue) Enter() func() {
+	q.gate.RLock()
+	return q.gate.RUnlock
+}
+
+// Pause holds off Enter until the returned func is called, nothing reaches
+// the cache or the queue meanwhile
+func (q *WriteQueue) Pause() func() {
+	q.gate.Lock()
+	return q.gate.Unlock
+}
+
+func (q *WriteQueue) Stats() WriteQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return WriteQueueStats{
+		Pending: len(q.pending),
+		Failed:  len(q.failed),
+	}
+}
+
+// Reset drops every job, used by initialize since the tables are recreated
+func (q *WriteQueue) Reset() error {
+	q.applyMu.Lock()
+	defer q.applyMu.Unlock()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending = nil
+	q.failed = nil
+	return q.rewrite()
+}
+
+// rewrite compacts the journal down to the failed jobs
+func (q *WriteQueue) rewrite() error {
+	tmp := q.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, job := range q.failed {
+		b, err := json.Marshal(job)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	if err := os.Rename(tmp, q.path); err != nil {
+		return err
+	}
+
+	q.file.Close()
+	q.file, err = os.OpenFile(q.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+func (q *WriteQueue) head() *writeJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		return nil
+	}
+	return q.pending[0]
+}
+
+// resolve marks the head job done or failed, jobs dropped by Reset are ignored
+func (q *WriteQueue) resolve(job *writeJob, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 || q.pending[0] != job {
+		return
+	}
+	q.pending = q.pending[1:]
+
+	marker := &writeJob{Seq: job.Seq, Done: true}
+	if err != nil {
+		job.Failed = err.Error()
+		marker = &writeJob{Seq: job.Seq, Failed: job.Failed}
+		q.failed = append(q.failed, job)
+	}
+	if err := q.append(marker); err != nil {
+		logger.Errorf("write queue journal err: %v", err)
+	}
+
+	if len(q.pending) == 0 {
+		if err := q.rewrite(); err != nil {
+			logger.Errorf("write queue compact err: %v", err)
+		}
+	}
+}
+
+// run applies the jobs in order, forever
+func (q *WriteQueue) run() {
+	for {
+		job := q.head()
+		if job == nil {
+			<-q.notify
+			continue
+		}
+
+		var err error
+		for attempt := 0; attempt < writeQueueMaxAttempts; attempt++ {
+			if attempt > 0 {
+				time.Sleep(writeQueueRetryDelay << uint(attempt-1))
+			}
+			q.applyMu.Lock()
+			if q.head() != job {
+				q.applyMu.Unlock()
+				err = nil
+				break
+			}
+			err = applyWriteJob(job)
+			q.applyMu.Unlock()
+			if err == nil {
+				break
+			}
+			logger.Errorf("write queue job %d (%s) attempt %d err: %v", job.Seq, job.Op, attempt+1, err)
+		}
+		q.resolve(job, err)
+	}
+}
+
+// newWriteJobID a random id, unique across processes and restarts
+func newWriteJobID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// applyWriteJob applies the job in one transaction with its applied_jobs
+// row, a job already recorded there committed before and is skipped
+func applyWriteJob(job *writeJob) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("INSERT INTO applied_jobs(id) VALUES(?)", job.ID); err != nil {
+		if isDuplicateEntry(err) {
+			logger.Infof("write queue job %d (%s) already applied", job.Seq, job.Op)
+			return nil
+		}
+		return err
+	}
+
+	switch job.Op {
+	case writeOpBuyChair:
+		err = applyBuyChair(tx, job.ChairID)
+	case writeOpInsertChairs:
+		err = applyInsertChairs(tx, job.Records)
+	case writeOpInsertEstates:
+		err = applyInsertEstates(tx, job.Records)
+	default:
+		err = fmt.Errorf("unknown write op %q", job.Op)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func isDuplicateEntry(err error) bool {
+	me, ok := err.(*mysql.MySQLError)
+	return ok && me.Number == 1062
+}
+
+func applyBuyChair(tx *sqlx.Tx, id int64) error {
+	var chair Chair
+	err := tx.QueryRowx("SELECT "+chairSelectColumns+" FROM chair WHERE id = ? AND stock > 0 FOR UPDATE", id).StructScan(&chair)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE chair SET stock = stock - 1 WHERE id = ?", id)
+	return err
+}
+
+func applyInsertChairs(tx *sqlx.Tx, records [][]string) error {
+	for _, record := range records {
+		row, err := parseChairRecord(record)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO chair(id, name, description, thumbnail, price, height, width, depth, color, features, kind, popularity, stock) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?)", row.ID, row.Name, row.Description, row.Thumbnail, row.Price, row.Height, row.Width, row.Depth, row.Color, row.Features, row.Kind, row.Popularity, row.Stock)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyInsertEstates(tx *sqlx.Tx, records [][]string) error {
+	for _, record := range records {
+		row, err := parseEstateRecord(record)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO estate(id, name, description, thumbnail, address, latitude, longitude, rent, door_height, door_width, features, popularity) VALUES(?,?,?,?,?,?,?,?,?,?,?,?)", row.ID, row.Name, row.Description, row.Thumbnail, row.Address, row.Latitude, row.Longitude, row.Rent, row.DoorHeight, row.DoorWidth, row.Features, row.Popularity)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
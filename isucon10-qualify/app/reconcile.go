@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/spf13/cast"
+)
+
+// reconcile winners, "redis" is whichever cache backend is configured
+const (
+	reconcileWinnerMySQL = "mysql"
+	reconcileWinnerRedis = "redis"
+)
+
+// diff kinds
+const (
+	reconcileMissingRedis = "missing_redis"
+	reconcileMissingMySQL = "missing_mysql"
+	reconcileMismatch     = "mismatch"
+)
+
+const reconcileScanCount = 1000
+
+var (
+	errReconcileWinner  = errors.New("unknown reconcile winner")
+	errReconcilePending = errors.New("write queue has pending jobs")
+)
+
+var reconcileMu sync.Mutex
+
+type ReconcileDiff struct {
+	Key      string `json:"key"`
+	Kind     string `json:"kind"`
+	Redis    string `json:"redis,omitempty"`
+	MySQL    string `json:"mysql,omitempty"`
+	Repaired bool   `json:"repaired"`
+}
+
+type ReconcileReport struct {
+	Winner   string          `json:"winner,omitempty"`
+	Duration string          `json:"duration"`
+	Diffs    []ReconcileDiff `json:"diffs"`
+}
+
+func reconcileWinner() string {
+	return getEnv("RECONCILE_WINNER", reconcileWinnerMySQL)
+}
+
+// startReconciler runs reconcile every RECONCILE_INTERVAL (e.g. "5m"), disabled when unset
+func startReconciler() {
+	interval, err := time.ParseDuration(getEnv("RECONCILE_INTERVAL", "0"))
+	if err != nil {
+		logger.Errorf("RECONCILE_INTERVAL invalid: %s", err)
+		return
+	}
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		for range time.Tick(interval) {
+			report, err := reconcile(reconcileWinner())
+			if err == errReconcilePending {
+				logger.Infof("reconcile skipped: %s", err)
+				continue
+			} else if err != nil {
+				logger.Errorf("reconcile err: %s", err)
+				continue
+			}
+			if len(report.Diffs) > 0 {
+				logger.Warnf("reconcile repaired %d diffs, winner: %s", len(report.Diffs), report.Winner)
+			}
+		}
+	}()
+}
+
+// reconcile compares the cached rows and stock with MySQL, when
+// winner is set the differences are repaired towards it.
+// Writes still in the write queue are expected drift, so repairs wait for it
+// to drain, and the queue is paused until the repair is written so no
+// purchase lands between the snapshot and the repair.
+func reconcile(winner string) (*ReconcileReport, error) {
+	if winner != "" && winner != reconcileWinnerMySQL && winner != reconcileWinnerRedis {
+		return nil, errReconcileWinner
+	}
+	if winner != "" {
+		resume := writeQueue.Pause()
+		defer resume()
+		if writeQueue.Stats().Pending > 0 {
+			return nil, errReconcilePending
+		}
+	}
+
+	reconcileMu.Lock()
+	defer reconcileMu.Unlock()
+
+	start := time.Now()
+
+	var chairs []*Chair
+	if err := db.Select(&chairs, "SELECT "+chairSelectColumns+" FROM chair"); err != nil {
+		return nil, err
+	}
+	var estates []*Estate
+	if err := db.Select(&estates, "SELECT "+estateSelectColumns+" FROM estate"); err != nil {
+		return nil, err
+	}
+
+	// what the cache should hold according to MySQL
+	expected := make(map[string]string, len(chairs)*2+len(estates))
+	chairByKey := make(map[string]*Chair, len(chairs)*2)
+	estateByKey := make(map[string]*Estate, len(estates))
+	for _, chair := range chairs {
+		id := cast.ToString(chair.ID)
+		if chair.Stock > 0 {
+			val, _ := jsoniter.MarshalToString(chair)
+			expected[CacheKeyChairID+id] = val
+			chairByKey[CacheKeyChairID+id] = chair
+		}
+		expected[cacheKey("chair", "stock", id)] = cast.ToString(chair.Stock)
+		chairByKey[cacheKey("chair", "stock", id)] = chair
+	}
+	for _, estate := range estates {
+		key := CacheKeyEstateID + cast.ToString(estate.ID)
+		val, _ := jsoniter.MarshalToString(estate)
+		expected[key] = val
+		estateByKey[key] = estate
+	}
+
+	actual := make(map[string]string, len(expected))
+	for _, pattern := range []string{CacheKeyChairID + "*", CacheKeyEstateID + "*", cacheKey("chair", "stock", "*")} {
+		if err := scanCache(pattern, actual); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &ReconcileReport{
+		Winner: winner,
+		Diffs:  []ReconcileDiff{},
+	}
+	for key, want := range expected {
+		got, ok := actual[key]
+		switch {
+		case !ok:
+			report.Diffs = append(report.Diffs, ReconcileDiff{Key: key, Kind: reconcileMissingRedis, MySQL: want})
+		case got != want:
+			report.Diffs = append(report.Diffs, ReconcileDiff{Key: key, Kind: reconcileMismatch, Redis: got, MySQL: want})
+		}
+	}
+	for key, got := range actual {
+		if _, ok := expected[key]; !ok {
+			report.Diffs = append(report.Diffs, ReconcileDiff{Key: key, Kind: reconcileMissingMySQL, Redis: got})
+		}
+	}
+
+	var err error
+	switch winner {
+	case reconcileWinnerMySQL:
+		err = repairRedis(report.Diffs, chairByKey, estateByKey)
+	case reconcileWinnerRedis:
+		err = repairMySQL(report.Diffs, estateByKey)
+	}
+
+	report.Duration = time.Since(start).String()
+	return report, err
+}
+
+func scanCache(pattern string, out map[string]string) error {
+	keys, err := cache.Keys(context.Background(), pattern)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(keys); start += reconcileScanCount {
+		end := start + reconcileScanCount
+		if end > len(keys) {
+			end = len(keys)
+		}
+		vals, err := cache.MGet(context.Background(), keys[start:end]...)
+		if err != nil {
+			return err
+		}
+		for k, v := range vals {
+			out[k] = v
+		}
+	}
+	return nil
+}
+
+// repairRedis rewrites the cache and the in-memory indexes from MySQL
+func repairRedis(diffs []ReconcileDiff, chairByKey map[string]*Chair, estateByKey map[string]*Estate) error {
+	ctx := context.Background()
+	pipe := cache.Pipeline()
+	chairs := make(map[int64]*Chair)
+	estates := make(map[int64]*Estate)
+	removed := make([]int64, 0)
+	for i := range diffs {
+		d := &diffs[i]
+		if d.Kind == reconcileMissingMySQL {
+			pipe.Del(d.Key)
+			if strings.HasPrefix(d.Key, CacheKeyChairID) {
+				removed = append(removed, cast.ToInt64(strings.TrimPrefix(d.Key, CacheKeyChairID)))
+			}
+		} else {
+			pipe.Set(d.Key, d.MySQL)
+		}
+		d.Repaired = true
+
+		if chair, ok := chairByKey[d.Key]; ok {
+			chairs[chair.ID] = chair
+		}
+		if estate, ok := estateByKey[d.Key]; ok {
+			estates[estate.ID] = estate
+		}
+	}
+	if err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	gone := make([]interface{}, 0, len(removed))
+	for _, id := range removed {
+		if _, ok := chairs[id]; !ok {
+			chairIndex.Remove(id)
+			lowPricedChairs.Remove(id)
+			chairTextIndex.Remove(id)
+			gone = append(gone, id)
+		}
+	}
+	if len(gone) > 0 {
+		if err := cache.SRem(ctx, cacheKey("chair", "instock"), gone...); err != nil {
+			return err
+		}
+	}
+	updated := make([]*Chair, 0, len(chairs))
+	for _, chair := range chairs {
+		chairIndex.Add(chair)
+		chairFitKeys.Add(chair)
+		lowPricedChairs.Remove(chair.ID)
+		lowPricedChairs.Add(chairLowPriced(chair)...)
+		chairTextIndex.Remove(chair.ID)
+		chairTextIndex.Add(chairTextDocs(chair)...)
+		updated = append(updated, chair)
+	}
+	if err := cacheChair(updated...); err != nil {
+		return err
+	}
+	rows := make([]*Estate, 0, len(estates))
+	for _, estate := range estates {
+		rows = append(rows, estate)
+	}
+	if len(rows) > 0 {
+		estateIndex.Add(rows...)
+		estateGeoIndex.Add(rows...)
+		estateFitIndex.Add(rows...)
+		lowPricedEstates.Add(estateLowPriced(rows...)...)
+		estateTextIndex.Add(estateTextDocs(rows...)...)
+	}
+	responseCache.Invalidate(responseChair, responseEstate)
+	return nil
+}
+
+// repairMySQL writes the cache back to MySQL. Cached rows leave out
+// popularity and stock, chairs take them from their popularity and stock
+// keys and estates from MySQL or the estate index. A row missing one of them
+// and a stock without its row are only reported.
+func repairMySQL(diffs []ReconcileDiff, estateByKey map[string]*Estate) error {
+	ctx := context.Background()
+	// rows first, a stock diff of an upserted chair is repaired with it
+	upserted := make(map[string]bool)
+	for i := range diffs {
+		d := &diffs[i]
+		if d.Kind == reconcileMissingRedis {
+			continue
+		}
+		var err error
+		switch {
+		case strings.HasPrefix(d.Key, CacheKeyChairID):
+			d.Repaired, err = upsertCachedChair(ctx, d.Redis)
+			if d.Repaired {
+				upserted[strings.TrimPrefix(d.Key, CacheKeyChairID)] = true
+			}
+		case strings.HasPrefix(d.Key, CacheKeyEstateID):
+			d.Repaired, err = upsertCachedEstate(d.Redis, estateByKey[d.Key])
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	stockPrefix := cacheKey("chair", "stock", "")
+	for i := range diffs {
+		d := &diffs[i]
+		if !strings.HasPrefix(d.Key, stockPrefix) {
+			continue
+		}
+		id := strings.TrimPrefix(d.Key, stockPrefix)
+		switch {
+		case upserted[id]:
+			d.Repaired = true
+		case d.Kind == reconcileMismatch:
+			if _, err := db.Exec("UPDATE chair SET stock = ? WHERE id = ?", cast.ToInt64(d.Redis), id); err != nil {
+				return err
+			}
+			d.Repaired = true
+		}
+	}
+	return nil
+}
+
+// upsertCachedChair writes a cached chair row with its cached popularity and
+// stock, false when one of them is gone
+func upsertCachedChair(ctx context.Context, val string) (bool, error) {
+	row := &Chair{}
+	if err := jsoniter.UnmarshalFromString(val, row); err != nil {
+		logger.Errorf("reconcile broken chair row: %s", err)
+		return false, nil
+	}
+	id := cast.ToString(row.ID)
+	popularityKey, stockKey := cacheKey("chair", "popularity", id), cacheKey("chair", "stock", id)
+	vals, err := cache.MGet(ctx, popularityKey, stockKey)
+	if err != nil {
+		return false, err
+	}
+	popularity, ok := vals[popularityKey]
+	if !ok {
+		return false, nil
+	}
+	stock, ok := vals[stockKey]
+	if !ok {
+		return false, nil
+	}
+	row.Popularity, row.Stock = cast.ToInt64(popularity), cast.ToInt64(stock)
+
+	_, err = db.Exec("INSERT INTO chair(id, name, description, thumbnail, price, height, width, depth, color, features, kind, popularity, stock) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?)"+
+		" ON DUPLICATE KEY UPDATE name = VALUES(name), description = VALUES(description), thumbnail = VALUES(thumbnail), price = VALUES(price), height = VALUES(height), width = VALUES(width), depth = VALUES(depth), color = VALUES(color), features = VALUES(features), kind = VALUES(kind), popularity = VALUES(popularity), stock = VALUES(stock)",
+		row.ID, row.Name, row.Description, row.Thumbnail, row.Price, row.Height, row.Width, row.Depth, row.Color, row.Features, row.Kind, row.Popularity, row.Stock)
+	return err == nil, err
+}
+
+// upsertCachedEstate writes a cached estate row, popularity is kept from
+// MySQL or else taken from the estate index, false when neither has it
+func upsertCachedEstate(val string, mysql *Estate) (bool, error) {
+	row := &Estate{}
+	if err := jsoniter.UnmarshalFromString(val, row); err != nil {
+		logger.Errorf("reconcile broken estate row: %s", err)
+		return false, nil
+	}
+	if mysql != nil {
+		row.Popularity = mysql.Popularity
+	} else if e, ok := estateIndex.get(row.ID); ok {
+		row.Popularity = e.estate.Popularity
+	} else {
+		return false, nil
+	}
+
+	_, err := db.Exec("INSERT INTO estate(id, name, description, thumbnail, address, latitude, longitude, rent, door_height, door_width, features, popularity) VALUES(?,?,?,?,?,?,?,?,?,?,?,?)"+
+		" ON DUPLICATE KEY UPDATE name = VALUES(name), description = VALUES(description), thumbnail = VALUES(thumbnail), address = VALUES(address), latitude = VALUES(latitude), longitude = VALUES(longitude), rent = VALUES(rent), door_height = VALUES(door_height), door_width = VALUES(door_width), features = VALUES(features), popularity = VALUES(popularity)",
+		row.ID, row.Name, row.Description, row.Thumbnail, row.Address, row.Latitude, row.Longitude, row.Rent, row.DoorHeight, row.DoorWidth, row.Features, row.Popularity)
+	return err == nil, err
+}
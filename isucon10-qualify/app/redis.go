@@ -2,133 +2,243 @@ package main
 
 import (
 	"context"
-	"os"
-	"strings"
+	"fmt"
+	"math"
+	"strconv"
 	"time"
 
 	"github.com/go-redis/redis/v8"
-	jsoniter "github.com/json-iterator/go"
-	"github.com/spf13/cast"
 )
 
-// cache key
-const (
-    CacheKeyChairID = "chair:id:"
-    CacheKeyEstateID = "estate:id:"
-)
+const redisScanCount = 1000
+
+// redisCache Cache backed by Redis
+type redisCache struct {
+	client *redis.Client
+}
+
+func newRedisCache(addr string) *redisCache {
+	rdb := redis.NewClient(&redis.Options{
+		Addr: addr,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		logger.Errorf("Redis ping err: %s", err)
+	}
+
+	return &redisCache{client: rdb}
+}
+
+func (rc *redisCache) Get(ctx context.Context, key string) (string, error) {
+	val, err := rc.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", errCacheMiss
+	}
+	return val, err
+}
+
+func (rc *redisCache) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	res := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return res, nil
+	}
+	vals, err := rc.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range vals {
+		if s, ok := v.(string); ok {
+			res[keys[i]] = s
+		}
+	}
+	return res, nil
+}
+
+func (rc *redisCache) Set(ctx context.Context, key string, value interface{}) error {
+	return rc.client.Set(ctx, key, value, 0).Err()
+}
+
+func (rc *redisCache) Del(ctx context.Context, keys ...string) error {
+	return rc.client.Del(ctx, keys...).Err()
+}
+
+func (rc *redisCache) Keys(ctx context.Context, pattern string) ([]string, error) {
+	keys := make([]string, 0)
+	var cursor uint64
+	for {
+		page, next, err := rc.client.Scan(ctx, cursor, pattern, redisScanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, page...)
+		cursor = next
+		if cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+func (rc *redisCache) FlushAll(ctx context.Context) error {
+	return rc.client.FlushAll(ctx).Err()
+}
+
+func (rc *redisCache) Pipeline() CachePipeline {
+	return &redisPipeline{pipe: rc.client.Pipeline()}
+}
+
+func (rc *redisCache) SAdd(ctx context.Context, key string, members ...interface{}) error {
+	return rc.client.SAdd(ctx, key, members...).Err()
+}
+
+func (rc *redisCache) SRem(ctx context.Context, key string, members ...interface{}) error {
+	return rc.client.SRem(ctx, key, members...).Err()
+}
+
+func (rc *redisCache) SMembers(ctx context.Context, key string) ([]string, error) {
+	return rc.client.SMembers(ctx, key).Result()
+}
+
+func (rc *redisCache) ZAdd(ctx context.Context, key string, members ...CacheZ) error {
+	return rc.client.ZAdd(ctx, key, redisZ(members)...).Err()
+}
+
+func (rc *redisCache) ZRem(ctx context.Context, key string, members ...interface{}) error {
+	return rc.client.ZRem(ctx, key, members...).Err()
+}
+
+func (rc *redisCache) ZInter(ctx context.Context, keys ...string) ([]string, error) {
+	return rc.client.ZInter(ctx, &redis.ZStore{Keys: keys}).Result()
+}
+
+// reserveStockScript checks and decrements the stock in one step, and drops
+// the row cache with the last one sold.
+// KEYS: stock key, row key
+// returns the stock left, -1 when the stock is unknown, -2 when sold out,
+// followed by the dropped row
+var reserveStockScript = redis.NewScript(`
+local stock = redis.call('GET', KEYS[1])
+if not stock then
+    return {-1}
+end
+if tonumber(stock) <= 0 then
+    return {-2}
+end
+local left = redis.call('DECR', KEYS[1])
+if left <= 0 then
+    local row = redis.call('GET', KEYS[2])
+    redis.call('DEL', KEYS[2])
+    return {left, row}
+end
+return {left}
+`)
+
+func (rc *redisCache) ReserveStock(ctx context.Context, stockKey, rowKey string) (int64, string, error) {
+	v, err := reserveStockScript.Run(ctx, rc.client, []string{stockKey, rowKey}).Result()
+	if err != nil {
+		return 0, "", err
+	}
+	res, _ := v.([]interface{})
+	if len(res) == 0 {
+		return 0, "", fmt.Errorf("reserve stock: unexpected reply %v", v)
+	}
+	left, _ := res[0].(int64)
+	switch left {
+	case -1:
+		return 0, "", errCacheMiss
+	case -2:
+		return 0, "", errCacheSoldOut
+	}
+	var row string
+	if len(res) > 1 {
+		row, _ = res[1].(string)
+	}
+	return left, row, nil
+}
+
+// releaseStockScript increments the stock and restores the row in one step
+// KEYS: stock key, row key
+// ARGV: row, empty to leave the row key alone
+var releaseStockScript = redis.NewScript(`
+redis.call('INCR', KEYS[1])
+if ARGV[1] ~= '' then
+    redis.call('SET', KEYS[2], ARGV[1])
+end
+return 0
+`)
+
+func (rc *redisCache) ReleaseStock(ctx context.Context, stockKey, rowKey, row string) error {
+	return releaseStockScript.Run(ctx, rc.client, []string{stockKey, rowKey}, row).Err()
+}
+
+func redisZ(members []CacheZ) []*redis.Z {
+	zs := make([]*redis.Z, 0, len(members))
+	for _, m := range members {
+		zs = append(zs, &redis.Z{Score: m.Score, Member: m.Member})
+	}
+	return zs
+}
+
+func redisScore(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+type redisPipeline struct {
+	pipe redis.Pipeliner
+}
+
+func (rp *redisPipeline) Set(key string, value interface{}) {
+	rp.pipe.Set(context.Background(), key, value, 0)
+}
+
+func (rp *redisPipeline) Del(keys ...string) {
+	rp.pipe.Del(context.Background(), keys...)
+}
+
+func (rp *redisPipeline) SAdd(key string, members ...interface{}) {
+	rp.pipe.SAdd(context.Background(), key, members...)
+}
+
+func (rp *redisPipeline) SRem(key string, members ...interface{}) {
+	rp.pipe.SRem(context.Background(), key, members...)
+}
+
+func (rp *redisPipeline) ZAdd(key string, members ...CacheZ) {
+	rp.pipe.ZAdd(context.Background(), key, redisZ(members)...)
+}
+
+func (rp *redisPipeline) ZRem(key string, members ...interface{}) {
+	rp.pipe.ZRem(context.Background(), key, members...)
+}
+
+func (rp *redisPipeline) ZRangeStoreByScore(dst, src string, min, max float64) {
+	rp.pipe.ZRangeStore(context.Background(), dst, redis.ZRangeArgs{
+		Key:     src,
+		Start:   redisScore(min),
+		Stop:    redisScore(max),
+		ByScore: true,
+	})
+}
+
+func (rp *redisPipeline) ZUnionStore(dst string, keys ...string) {
+	rp.pipe.ZUnionStore(context.Background(), dst, &redis.ZStore{Keys: keys})
+}
+
+func (rp *redisPipeline) Expire(key string, ttl time.Duration) {
+	rp.pipe.Expire(context.Background(), key, ttl)
+}
 
-var redisClient *redis.Client
-
-func cacheKey(arr ...string) string {
-    return strings.Join(arr, ":")
-}
-
-func initRedis() {
-    addr := os.Getenv("REDIS_ADDR")
-    if addr == "" {
-        logger.Error("Redis addr env empty")
-        return
-    }
-
-    rdb := redis.NewClient(&redis.Options{
-        Addr: addr,
-    })
-
-    ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second) 
-    defer cancel()
-    if err := rdb.Ping(ctx); err != nil {
-        logger.Errorf("Redis ping err: %s", err)
-    }
-
-    redisClient = rdb
-}
-
-func tablesCache() {
-    {
-        query := "SELECT * FROM chair"
-        data := make([]*Chair, 0, 3200)
-        if err := db.Select(&data, query); err != nil {
-            logger.Errorf("chair table query err: %s", err)
-        }
-    
-        pipe := redisClient.Pipeline()
-        for _, row := range data {
-            cacheRow(CacheKeyChairID, row.ID, row, pipe)
-        }
-        if _, err := pipe.Exec(context.Background()); err != nil {
-            logger.Errorf("redis cache chair err: %s", err)
-        }
-
-        if err := cacheChair(data...); err != nil {
-            logger.Errorf("cache chair err: %s", err)
-        }
-    }
-
-    {
-        query := "SELECT * FROM estate"
-        data := make([]Estate, 0, 3200)
-        if err := db.Select(&data, query); err != nil {
-            logger.Errorf("estate table query err: %s", err)
-        }
-    
-        pipe := redisClient.Pipeline()
-        for _, row := range data {
-            cacheRow(CacheKeyChairID, row.ID, row, pipe)
-        }
-        if _, err := pipe.Exec(context.Background()); err != nil {
-            logger.Errorf("redis cache estate err: %s", err)
-        }
-    }
-}
-
-func cacheRow(prefix string, id interface{}, row interface{}, r redis.Pipeliner) {
-    val, _ := jsoniter.MarshalToString(row)
-    var err error
-    if r != nil {
-        err = r.Set(context.Background(), prefix + cast.ToString(id), val, 0).Err()
-    } else {
-        err = redisClient.Set(context.Background(), prefix + cast.ToString(id), val, 
-        0).Err()
-    }
-    if err != nil {
-        logger.Errorf("redis cache row err: %s", err)
-    }
-}
-
-func fetchCacheRow(prefix string, id interface{}, data interface{}) error {
-    val, err := redisClient.Get(context.Background(), prefix + cast.ToString(id)).Result()
-    if err != nil {
-        return err
-    }
-    if err := jsoniter.UnmarshalFromString(val, data); err != nil {
-        return err
-    }
-    return nil
-}
-
-func cacheChair(arr ...*Chair) error {
-    pipe := redisClient.Pipeline()
-    for _, row := range arr {
-        pipe.SAdd(context.Background(), cacheKey("chair", "color", row.Color), row.ID)
-        pipe.SAdd(context.Background(), cacheKey("chair", "kind", row.Kind), row.ID)
-        pipe.ZAdd(context.Background(), cacheKey("chair", "price"), &redis.Z{
-            Score: float64(row.Price),
-            Member: row.ID,
-        })
-        pipe.ZAdd(context.Background(), cacheKey("chair", "height"), &redis.Z{
-            Score: float64(row.Height),
-            Member: row.ID,
-        })
-        pipe.ZAdd(context.Background(), cacheKey("chair", "width"), &redis.Z{
-            Score: float64(row.Width),
-            Member: row.ID,
-        })
-        pipe.ZAdd(context.Background(), cacheKey("chair", "depth"), &redis.Z{
-            Score: float64(row.Depth),
-            Member: row.ID,
-        })
-        pipe.Set(context.Background(), cacheKey("chair", "popularity", cast.ToString(row.ID)), row.Popularity, 0)
-        pipe.Set(context.Background(), cacheKey("chair", "stock", cast.ToString(row.ID)), row.Stock, 0)
-    }
-    return nil
-}
\ No newline at end of file
+func (rp *redisPipeline) Exec(ctx context.Context) error {
+	_, err := rp.pipe.Exec(ctx)
+	if err == redis.Nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"container/list"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// response cache resources, a write to one drops every response built from it
+const (
+	responseChair     = "chair"
+	responseEstate    = "estate"
+	responseCondition = "condition"
+)
+
+// responseCacheBytes the bodies and keys kept over every resource, the least
+// recently used responses are dropped first
+const responseCacheBytes = 64 << 20
+
+var responseCache = NewResponseCache(responseCacheBytes)
+
+type cachedResponse struct {
+	body        []byte
+	contentType string
+	etag        string
+}
+
+// responseEntry a cached response in the LRU list
+type responseEntry struct {
+	resource string
+	key      string
+	res      *cachedResponse
+}
+
+func (e *responseEntry) size() int {
+	return len(e.key) + len(e.res.body)
+}
+
+type responseBucket struct {
+	version uint64
+	entries map[string]*list.Element
+}
+
+// ResponseCache keeps encoded GET responses keyed by path and normalized
+// query, tagged with the version of the resource they were built from
+type ResponseCache struct {
+	mu       sync.Mutex
+	buckets  map[string]*responseBucket
+	lru      *list.List
+	size     int
+	maxBytes int
+}
+
+func NewResponseCache(maxBytes int) *ResponseCache {
+	return &ResponseCache{
+		buckets:  make(map[string]*responseBucket),
+		lru:      list.New(),
+		maxBytes: maxBytes,
+	}
+}
+
+// Invalidate drops the responses of the resources and bumps their versions
+func (rc *ResponseCache) Invalidate(resources ...string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for _, resource := range resources {
+		b := rc.bucket(resource)
+		b.version++
+		for _, el := range b.entries {
+			rc.size -= el.Value.(*responseEntry).size()
+			rc.lru.Remove(el)
+		}
+		b.entries = make(map[string]*list.Element)
+	}
+}
+
+func (rc *ResponseCache) bucket(resource string) *responseBucket {
+	b, ok := rc.buckets[resource]
+	if !ok {
+		b = &responseBucket{entries: make(map[string]*list.Element)}
+		rc.buckets[resource] = b
+	}
+	return b
+}
+
+func (rc *ResponseCache) get(resource, key string) (*cachedResponse, uint64) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	b, ok := rc.buckets[resource]
+	if !ok {
+		return nil, 0
+	}
+	el, ok := b.entries[key]
+	if !ok {
+		return nil, b.version
+	}
+	rc.lru.MoveToFront(el)
+	return el.Value.(*responseEntry).res, b.version
+}
+
+// put stores the response unless the resource changed since version, a
+// response above the whole budget is not kept
+func (rc *ResponseCache) put(resource, key string, version uint64, res *cachedResponse) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	b := rc.bucket(resource)
+	if b.version != version {
+		return
+	}
+	e := &responseEntry{resource: resource, key: key, res: res}
+	if e.size() > rc.maxBytes {
+		return
+	}
+	if el, ok := b.entries[key]; ok {
+		rc.size -= el.Value.(*responseEntry).size()
+		rc.lru.Remove(el)
+	}
+	for rc.size+e.size() > rc.maxBytes {
+		rc.evict()
+	}
+	b.entries[key] = rc.lru.PushFront(e)
+	rc.size += e.size()
+}
+
+// evict drops the least recently used response
+func (rc *ResponseCache) evict() {
+	el := rc.lru.Back()
+	e := el.Value.(*responseEntry)
+	rc.lru.Remove(el)
+	delete(rc.buckets[e.resource].entries, e.key)
+	rc.size -= e.size()
+}
+
+// cached serves the handler's 200 responses from the response cache, with
+// an ETag so clients can revalidate with If-None-Match
+func cached(resource string, h fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := responseCacheKey(c)
+		res, version := responseCache.get(resource, key)
+		if res == nil {
+			if err := h(c); err != nil {
+				return err
+			}
+			if c.Response().StatusCode() != http.StatusOK {
+				return nil
+			}
+			body := c.Response().Body()
+			res = &cachedResponse{
+				body:        append([]byte(nil), body...),
+				contentType: string(c.Response().Header.ContentType()),
+				etag:        responseETag(version, body),
+			}
+			responseCache.put(resource, key, version, res)
+		}
+
+		c.Set(fiber.HeaderETag, res.etag)
+		if etagMatch(c.Get(fiber.HeaderIfNoneMatch), res.etag) {
+			c.Response().ResetBody()
+			return c.SendStatus(http.StatusNotModified)
+		}
+		c.Set(fiber.HeaderContentType, res.contentType)
+		return c.Send(res.body)
+	}
+}
+
+// responseCacheKey the path and the query with its keys sorted
+func responseCacheKey(c *fiber.Ctx) string {
+	values := url.Values{}
+	c.Context().QueryArgs().VisitAll(func(k, v []byte) {
+		values.Add(string(k), string(v))
+	})
+	return c.Path() + "?" + values.Encode()
+}
+
+func responseETag(version uint64, body []byte) string {
+	h := fnv.New64a()
+	h.Write(body)
+	return `"` + strconv.FormatUint(version, 36) + "-" + strconv.FormatUint(h.Sum64(), 36) + `"`
+}
+
+// etagMatch the If-None-Match comparison, weak tags match their strong form
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestETagMatch(t *testing.T) {
+	const etag = `"1-abc"`
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"empty", "", false},
+		{"same", `"1-abc"`, true},
+		{"weak", `W/"1-abc"`, true},
+		{"other", `"2-abc"`, false},
+		{"list", `"0-x", "1-abc"`, true},
+		{"list without", `"0-x", W/"2-abc"`, false},
+		{"any", "*", true},
+		{"unquoted", "1-abc", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatch(tt.header, etag); got != tt.want {
+				t.Errorf("etagMatch(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseCacheBudget(t *testing.T) {
+	body := func(n int) *cachedResponse { return &cachedResponse{body: make([]byte, n)} }
+	// keys are 2 bytes, every entry below is 100 bytes
+	rc := NewResponseCache(300)
+	rc.put(responseChair, "c1", 0, body(98))
+	rc.put(responseChair, "c2", 0, body(98))
+	rc.put(responseEstate, "e1", 0, body(98))
+	// c1 is used, c2 is the least recent and goes first
+	if res, _ := rc.get(responseChair, "c1"); res == nil {
+		t.Fatal("c1 missing before the budget is reached")
+	}
+	rc.put(responseEstate, "e2", 0, body(98))
+
+	has := func(resource, key string) bool {
+		res, _ := rc.get(resource, key)
+		return res != nil
+	}
+	if has(responseChair, "c2") || !has(responseChair, "c1") || !has(responseEstate, "e1") || !has(responseEstate, "e2") {
+		t.Fatalf("want c2 evicted, c1, e1 and e2 kept")
+	}
+	if rc.size != 300 {
+		t.Errorf("size = %d, want 300", rc.size)
+	}
+
+	// replacing a key does not count it twice
+	rc.put(responseEstate, "e2", 0, body(48))
+	if rc.size != 250 {
+		t.Errorf("size = %d, want 250", rc.size)
+	}
+
+	// above the whole budget nothing is kept or evicted
+	rc.put(responseChair, "c3", 0, body(400))
+	if has(responseChair, "c3") || rc.size != 250 {
+		t.Errorf("oversized response kept, size = %d", rc.size)
+	}
+
+	// invalidation frees the bytes and stale versions are not stored
+	rc.Invalidate(responseEstate)
+	if rc.size != 100 || has(responseEstate, "e1") {
+		t.Errorf("size = %d after invalidate, want 100", rc.size)
+	}
+	rc.put(responseEstate, "e3", 0, body(98))
+	if has(responseEstate, "e3") {
+		t.Error("response of a stale version kept")
+	}
+}
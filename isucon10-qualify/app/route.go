@@ -1,6 +1,10 @@
 package main
 
-import "github.com/gofiber/fiber/v2"
+import (
+    "net/http"
+
+    "github.com/gofiber/fiber/v2"
+)
 
 func routeRegister(s *fiber.App) {
     // Initialize
@@ -8,19 +12,31 @@ func routeRegister(s *fiber.App) {
 
     // Chair Handler
     s.Post("/api/chair", postChair)
-    s.Get("/api/chair/search", searchChairs)
-    s.Get("/api/chair/low_priced", getLowPricedChair)
-    s.Get("/api/chair/search/condition", getChairSearchCondition)
+    s.Get("/api/chair/search", cached(responseChair, searchChairs))
+    s.Get("/api/chair/low_priced", cached(responseChair, getLowPricedChair))
+    s.Get("/api/chair/search/condition", cached(responseCondition, getChairSearchCondition))
     s.Get("/api/chair/:id", getChairDetail)
     s.Post("/api/chair/buy/:id", buyChair)
 
     // Estate Handler
     s.Post("/api/estate", postEstate)
-    s.Get("/api/estate/search", searchEstates)
-    s.Get("/api/estate/low_priced", getLowPricedEstate)
+    s.Get("/api/estate/search", cached(responseEstate, searchEstates))
+    s.Get("/api/estate/low_priced", cached(responseEstate, getLowPricedEstate))
     s.Post("/api/estate/req_doc/:id", postEstateRequestDocument)
     s.Post("/api/estate/nazotte", searchEstateNazotte)
-    s.Get("/api/estate/search/condition", getEstateSearchCondition)
+    s.Get("/api/estate/nearby", cached(responseEstate, searchEstateNearby))
+    s.Get("/api/estate/clusters", cached(responseEstate, getEstateClusters))
+    s.Get("/api/estate/search/condition", cached(responseCondition, getEstateSearchCondition))
     s.Get("/api/estate/:id", getEstateDetail)
     s.Get("/api/recommended_estate/:id", searchRecommendedEstateWithChair)
+
+    // Admin Handler
+    s.Get("/api/admin/write_queue", getWriteQueueStats)
+    s.Get("/api/admin/reconcile", getReconcile)
+    s.Post("/api/admin/reconcile", postReconcile)
+
+    // Unknown routes, as JSON like every other error
+    s.Use(func(c *fiber.Ctx) error {
+        return newAPIError(http.StatusNotFound, "not_found", "cannot %s %s", c.Method(), c.Path())
+    })
 }
\ No newline at end of file
@@ -0,0 +1,96 @@
+package main
+
+import "errors"
+
+var errUnknownSort = errors.New("unknown sort")
+
+// chairSort an order of chair search results, ascending key then id
+type chairSort struct {
+	name string
+	key  func(c *Chair) int64
+	// keySQL the column of the key, indexed with id in 0_Index.sql
+	keySQL string
+}
+
+func (s *chairSort) less(a, b *Chair) bool {
+	ka, kb := s.key(a), s.key(b)
+	if ka != kb {
+		return ka < kb
+	}
+	return a.ID < b.ID
+}
+
+func chairSize(c *Chair) int64 {
+	return c.Width * c.Height * c.Depth
+}
+
+// chairSorts "newest" is the highest id first, ids only grow as chairs are
+// posted and there is no creation time
+var chairSorts = []*chairSort{
+	{"popularity", func(c *Chair) int64 { return -c.Popularity }, "popularity_desc"},
+	{"price_asc", func(c *Chair) int64 { return c.Price }, "price"},
+	{"price_desc", func(c *Chair) int64 { return -c.Price }, "price_desc"},
+	{"size_asc", chairSize, "size"},
+	{"size_desc", func(c *Chair) int64 { return -chairSize(c) }, "size_desc"},
+	{"newest", func(c *Chair) int64 { return -c.ID }, "id_desc"},
+}
+
+// defaultChairSort popularity_desc, id like the original search
+var defaultChairSort = chairSorts[0]
+
+// getChairSort the sort named by the sort parameter, "" is the default
+func getChairSort(name string) (*chairSort, error) {
+	if name == "" {
+		return defaultChairSort, nil
+	}
+	for _, s := range chairSorts {
+		if s.name == name {
+			return s, nil
+		}
+	}
+	return nil, errUnknownSort
+}
+
+// estateSort an order of estate search results, ascending key then id
+type estateSort struct {
+	name string
+	key  func(e *Estate) int64
+}
+
+func (s *estateSort) less(a, b *Estate) bool {
+	ka, kb := s.key(a), s.key(b)
+	if ka != kb {
+		return ka < kb
+	}
+	return a.ID < b.ID
+}
+
+func estateSize(e *Estate) int64 {
+	return e.DoorWidth * e.DoorHeight
+}
+
+// estateSorts "newest" is the highest id first like chairSorts
+var estateSorts = []*estateSort{
+	{"popularity", func(e *Estate) int64 { return -e.Popularity }},
+	{"rent_asc", func(e *Estate) int64 { return e.Rent }},
+	{"rent_desc", func(e *Estate) int64 { return -e.Rent }},
+	{"size_asc", estateSize},
+	{"size_desc", func(e *Estate) int64 { return -estateSize(e) }},
+	{"newest", func(e *Estate) int64 { return -e.ID }},
+}
+
+// defaultEstateSort popularity_desc, id like the original search
+var defaultEstateSort = estateSorts[0]
+
+// getEstateSort the sort named by the sort parameter, "" is the default
+func getEstateSort(name string) (*estateSort, error) {
+	if name == "" {
+		return defaultEstateSort, nil
+	}
+	for _, s := range estateSorts {
+		if s.name == name {
+			return s, nil
+		}
+	}
+	return nil, errUnknownSort
+}
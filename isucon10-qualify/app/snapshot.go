@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// insertable columns, popularity_desc, the chair sort keys and estate.point
+// are generated
+const (
+	chairColumns  = "id, name, description, thumbnail, price, height, width, depth, color, features, kind, popularity, stock"
+	estateColumns = "id, name, description, thumbnail, address, latitude, longitude, rent, door_height, door_width, features, popularity"
+)
+
+// the columns of Chair and Estate, the chair sort keys only serve their
+// indexes and estate.point the SPATIAL index
+const (
+	chairSelectColumns  = chairColumns + ", popularity_desc"
+	estateSelectColumns = estateColumns + ", popularity_desc"
+)
+
+var snapshotTables = []struct {
+	name    string
+	columns string
+}{
+	{"chair", chairColumns},
+	{"estate", estateColumns},
+}
+
+var tableSnapshot = &TableSnapshot{
+	path: getEnv("SNAPSHOT_PATH", "snapshot.gob"),
+}
+
+// initialSQLStages the scripts initialize loads, see loadSQLFiles
+func initialSQLStages() [][]string {
+	sqlDir := filepath.Join("..", "mysql", "db")
+	return [][]string{
+		{filepath.Join(".", "0_Schema.sql")},
+		// estate and chair are independent
+		{
+			filepath.Join(sqlDir, "1_DummyEstateData.sql"),
+			filepath.Join(sqlDir, "2_DummyChairData.sql"),
+		},
+		// indexes are cheaper to build once the rows are in
+		{filepath.Join(".", "0_Index.sql")},
+	}
+}
+
+// sqlFingerprint changes whenever one of the scripts does
+func sqlFingerprint(stages [][]string) (string, error) {
+	parts := make([]string, 0)
+	for _, files := range stages {
+		for _, path := range files {
+			st, err := os.Stat(path)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, fmt.Sprintf("%s:%d:%d", path, st.Size(), st.ModTime().UnixNano()))
+		}
+	}
+	return strings.Join(parts, ","), nil
+}
+
+type snapshotData struct {
+	Fingerprint string
+	Chairs      []*Chair
+	Estates     []*Estate
+}
+
+// TableSnapshot makes initialize cheap after the first time.
+// The first load copies the tables into <table>_snapshot and keeps the rows,
+// serialized to path so a restart can reuse them. A restore renames a
+// pre-populated <table>_shadow copy into place and refills the next shadow
+// in the background. The indexes built over the rows are serialized next to
+// path, see indexSnapshot.
+type TableSnapshot struct {
+	path string
+
+	mu     sync.Mutex
+	data   *snapshotData
+	index  *indexSnapshot
+	shadow chan error
+}
+
+// Restore resets the tables to the initial data and returns its rows
+func (ts *TableSnapshot) Restore(ctx context.Context) ([]*Chair, []*Estate, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	stages := initialSQLStages()
+	fp, err := sqlFingerprint(stages)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if ts.data == nil {
+		ts.data = ts.read(fp)
+	}
+	if ts.data != nil && ts.data.Fingerprint == fp {
+		err := ts.swap()
+		if err == nil {
+			return ts.rows()
+		}
+		logger.Errorf("snapshot restore failed, reloading : %v", err)
+	}
+
+	// full load, then capture
+	ts.data = nil
+	if err := loadSQLFiles(ctx, stages...); err != nil {
+		return nil, nil, err
+	}
+	if err := ts.capture(fp); err != nil {
+		return nil, nil, err
+	}
+	return ts.rows()
+}
+
+// rows copies the snapshot so the caches never share rows with it
+func (ts *TableSnapshot) rows() ([]*Chair, []*Estate, error) {
+	chairs := make([]*Chair, 0, len(ts.data.Chairs))
+	for _, c := range ts.data.Chairs {
+		chair := *c
+		chairs = append(chairs, &chair)
+	}
+	estates := make([]*Estate, 0, len(ts.data.Estates))
+	for _, e := range ts.data.Estates {
+		estate := *e
+		estates = append(estates, &estate)
+	}
+	return chairs, estates, nil
+}
+
+func (ts *TableSnapshot) capture(fp string) error {
+	data := &snapshotData{Fingerprint: fp}
+	if err := db.Select(&data.Chairs, "SELECT "+chairSelectColumns+" FROM chair"); err != nil {
+		return err
+	}
+	if err := db.Select(&data.Estates, "SELECT "+estateSelectColumns+" FROM estate"); err != nil {
+		return err
+	}
+
+	for _, t := range snapshotTables {
+		stmts := []string{
+			fmt.Sprintf("DROP TABLE IF EXISTS %s_snapshot", t.name),
+			fmt.Sprintf("CREATE TABLE %s_snapshot LIKE %s", t.name, t.name),
+			fmt.Sprintf("INSERT INTO %s_snapshot (%s) SELECT %s FROM %s", t.name, t.columns, t.columns, t.name),
+		}
+		for _, st := range stmts {
+			if _, err := db.Exec(st); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := writeGob(ts.path, data); err != nil {
+		// still usable until the next restart
+		logger.Errorf("snapshot write failed : %v", err)
+	}
+	ts.data = data
+	// built again over the new rows, see restoreIndexes
+	ts.index = nil
+	if err := os.Remove(ts.indexPath()); err != nil && !os.IsNotExist(err) {
+		logger.Errorf("index snapshot remove failed : %v", err)
+	}
+	ts.prepareShadow()
+	return nil
+}
+
+// prepareShadow refills <table>_shadow from the snapshot in the background
+func (ts *TableSnapshot) prepareShadow() {
+	done := make(chan error, 1)
+	ts.shadow = done
+	go func() {
+		for _, t := range snapshotTables {
+			stmts := []string{
+				fmt.Sprintf("DROP TABLE IF EXISTS %s_shadow", t.name),
+				fmt.Sprintf("CREATE TABLE %s_shadow LIKE %s_snapshot", t.name, t.name),
+				fmt.Sprintf("INSERT INTO %s_shadow (%s) SELECT %s FROM %s_snapshot", t.name, t.columns, t.columns, t.name),
+			}
+			for _, st := range stmts {
+				if _, err := db.Exec(st); err != nil {
+					done <- err
+					return
+				}
+			}
+		}
+		done <- nil
+	}()
+}
+
+// swap renames the shadow tables into place in one statement
+func (ts *TableSnapshot) swap() error {
+	if ts.shadow == nil {
+		ts.prepareShadow()
+	}
+	if err := <-ts.shadow; err != nil {
+		return err
+	}
+	ts.shadow = nil
+
+	renames := make([]string, 0)
+	drops := make([]string, 0)
+	for _, t := range snapshotTables {
+		renames = append(renames,
+			fmt.Sprintf("%s TO %s_old", t.name, t.name),
+			fmt.Sprintf("%s_shadow TO %s", t.name, t.name),
+		)
+		drops = append(drops, t.name+"_old")
+	}
+	if _, err := db.Exec("RENAME TABLE " + strings.Join(renames, ", ")); err != nil {
+		return err
+	}
+	if _, err := db.Exec("DROP TABLE " + strings.Join(drops, ", ")); err != nil {
+		logger.Errorf("snapshot drop old tables err : %v", err)
+	}
+
+	ts.prepareShadow()
+	return nil
+}
+
+// read loads the serialized snapshot, as long as it matches fp and the
+// snapshot tables still hold it
+func (ts *TableSnapshot) read(fp string) *snapshotData {
+	f, err := os.Open(ts.path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	data := &snapshotData{}
+	if err := gob.NewDecoder(f).Decode(data); err != nil {
+		logger.Errorf("snapshot decode err : %v", err)
+		return nil
+	}
+	if data.Fingerprint != fp {
+		return nil
+	}
+
+	var chairs, estates int
+	if err := db.Get(&chairs, "SELECT COUNT(*) FROM chair_snapshot"); err != nil || chairs != len(data.Chairs) {
+		return nil
+	}
+	if err := db.Get(&estates, "SELECT COUNT(*) FROM estate_snapshot"); err != nil || estates != len(data.Estates) {
+		return nil
+	}
+	return data
+}
+
+// indexPath snapshot.gob's indexes in snapshot.index.gob
+func (ts *TableSnapshot) indexPath() string {
+	return strings.TrimSuffix(ts.path, filepath.Ext(ts.path)) + ".index.gob"
+}
+
+// Index the indexes built over the rows of the last Restore, nil until
+// SaveIndex
+func (ts *TableSnapshot) Index() *indexSnapshot {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.data == nil {
+		return nil
+	}
+	if ts.index == nil {
+		f, err := os.Open(ts.indexPath())
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+
+		idx := &indexSnapshot{}
+		if err := gob.NewDecoder(f).Decode(idx); err != nil {
+			logger.Errorf("index snapshot decode err : %v", err)
+			return nil
+		}
+		ts.index = idx
+	}
+	if ts.index.Fingerprint != ts.data.Fingerprint {
+		return nil
+	}
+	return ts.index
+}
+
+// SaveIndex keeps the indexes built over the rows of the last Restore
+func (ts *TableSnapshot) SaveIndex(idx *indexSnapshot) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.data == nil {
+		return
+	}
+	idx.Fingerprint = ts.data.Fingerprint
+	ts.index = idx
+	if err := writeGob(ts.indexPath(), idx); err != nil {
+		// still usable until the next restart
+		logger.Errorf("index snapshot write failed : %v", err)
+	}
+}
+
+// writeGob replaces path with v, never leaving it half written
+func writeGob(path string, v interface{}) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(v); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
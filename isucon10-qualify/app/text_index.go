@@ -0,0 +1,293 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// maxTextQuery longest q accepted, in runes
+const maxTextQuery = 100
+
+var (
+	chairTextIndex  = NewTextIndex()
+	estateTextIndex = NewTextIndex()
+)
+
+// textDoc the searchable text of a row, its name and description
+type textDoc struct {
+	id   int64
+	text string
+}
+
+// chairTextDocs the docs of the chairs in stock
+func chairTextDocs(chairs ...*Chair) []textDoc {
+	docs := make([]textDoc, 0, len(chairs))
+	for _, chair := range chairs {
+		if chair.Stock <= 0 {
+			continue
+		}
+		docs = append(docs, textDoc{id: chair.ID, text: chair.Name + "\n" + chair.Description})
+	}
+	return docs
+}
+
+func estateTextDocs(estates ...*Estate) []textDoc {
+	docs := make([]textDoc, 0, len(estates))
+	for _, estate := range estates {
+		docs = append(docs, textDoc{id: estate.ID, text: estate.Name + "\n" + estate.Description})
+	}
+	return docs
+}
+
+// normalizeText lower cases and folds full-width ASCII, e.g. "ＡＢＣ" to "abc"
+func normalizeText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '！' && r <= '～' {
+			r = r - '！' + '!'
+		} else if r == '　' {
+			r = ' '
+		}
+		return r
+	}, strings.ToLower(s))
+}
+
+// bigrams the distinct pairs of adjacent runes, Japanese has no word
+// boundaries so every n-gram is a token
+func bigrams(s string) []string {
+	runes := []rune(s)
+	seen := make(map[string]struct{}, len(runes))
+	grams := make([]string, 0, len(runes))
+	for i := 0; i+1 < len(runes); i++ {
+		g := string(runes[i : i+2])
+		if _, ok := seen[g]; ok {
+			continue
+		}
+		seen[g] = struct{}{}
+		grams = append(grams, g)
+	}
+	return grams
+}
+
+// TextMatch the rows matching every term of a q parameter
+type TextMatch struct {
+	Terms []string
+	ids   map[int64]struct{}
+}
+
+// match reports whether the row matches, a nil TextMatch matches everything
+func (m *TextMatch) match(id int64) bool {
+	if m == nil {
+		return true
+	}
+	_, ok := m.ids[id]
+	return ok
+}
+
+// IDs the matching rows in id order
+func (m *TextMatch) IDs() []int64 {
+	ids := make([]int64, 0, len(m.ids))
+	for id := range m.ids {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// parseTextQuery splits q into its whitespace separated terms
+func parseTextQuery(q string) ([]string, error) {
+	if utf8.RuneCountInString(q) > maxTextQuery {
+		return nil, invalid("q", "must be at most %d characters", maxTextQuery)
+	}
+	terms := strings.Fields(normalizeText(q))
+	if len(terms) == 0 {
+		return nil, invalid("q", "must have a keyword")
+	}
+	return terms, nil
+}
+
+// TextIndex bigram inverted index over name and description. Bigrams only
+// narrow the candidates, every candidate is checked for the terms as
+// substrings so the result is the same as a LIKE on each term.
+type TextIndex struct {
+	mu       sync.RWMutex
+	texts    map[int64]string
+	postings map[string][]int64
+}
+
+func NewTextIndex() *TextIndex {
+	return &TextIndex{
+		texts:    make(map[int64]string),
+		postings: make(map[string][]int64),
+	}
+}
+
+// Load replaces the whole index
+func (ti *TextIndex) Load(docs []textDoc) {
+	texts := make(map[int64]string, len(docs))
+	postings := make(map[string][]int64)
+	for _, doc := range docs {
+		text := normalizeText(doc.text)
+		texts[doc.id] = text
+		for _, g := range bigrams(text) {
+			postings[g] = append(postings[g], doc.id)
+		}
+	}
+	for _, ids := range postings {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+
+	ti.mu.Lock()
+	ti.texts = texts
+	ti.postings = postings
+	ti.mu.Unlock()
+}
+
+// textIndexData the normalized texts and postings of a TextIndex, see
+// indexSnapshot
+type textIndexData struct {
+	Texts    map[int64]string
+	Postings map[string][]int64
+}
+
+// Data copies the index, Add and Remove edit the postings in place
+func (ti *TextIndex) Data() textIndexData {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+	return copyTextIndexData(ti.texts, ti.postings)
+}
+
+// LoadData Load from Data instead of tokenizing, the docs must be the ones
+// it was built from
+func (ti *TextIndex) LoadData(docs []textDoc, data textIndexData) error {
+	if len(data.Texts) != len(docs) {
+		return errIndexSnapshotMismatch
+	}
+	for _, doc := range docs {
+		if _, ok := data.Texts[doc.id]; !ok {
+			return errIndexSnapshotMismatch
+		}
+	}
+	data = copyTextIndexData(data.Texts, data.Postings)
+
+	ti.mu.Lock()
+	ti.texts = data.Texts
+	ti.postings = data.Postings
+	ti.mu.Unlock()
+	return nil
+}
+
+func copyTextIndexData(texts map[int64]string, postings map[string][]int64) textIndexData {
+	data := textIndexData{
+		Texts:    make(map[int64]string, len(texts)),
+		Postings: make(map[string][]int64, len(postings)),
+	}
+	for id, text := range texts {
+		data.Texts[id] = text
+	}
+	for g, ids := range postings {
+		data.Postings[g] = append([]int64(nil), ids...)
+	}
+	return data
+}
+
+// Add inserts or replaces docs
+func (ti *TextIndex) Add(docs ...textDoc) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	for _, doc := range docs {
+		ti.remove(doc.id)
+		text := normalizeText(doc.text)
+		ti.texts[doc.id] = text
+		for _, g := range bigrams(text) {
+			ids := ti.postings[g]
+			i := sort.Search(len(ids), func(i int) bool { return ids[i] >= doc.id })
+			ids = append(ids, 0)
+			copy(ids[i+1:], ids[i:])
+			ids[i] = doc.id
+			ti.postings[g] = ids
+		}
+	}
+}
+
+// Remove drops a doc, e.g. when the chair is sold out
+func (ti *TextIndex) Remove(id int64) {
+	ti.mu.Lock()
+	ti.remove(id)
+	ti.mu.Unlock()
+}
+
+func (ti *TextIndex) remove(id int64) {
+	text, ok := ti.texts[id]
+	if !ok {
+		return
+	}
+	delete(ti.texts, id)
+	for _, g := range bigrams(text) {
+		ids := ti.postings[g]
+		i := sort.Search(len(ids), func(i int) bool { return ids[i] >= id })
+		if i < len(ids) && ids[i] == id {
+			ids = append(ids[:i], ids[i+1:]...)
+		}
+		if len(ids) == 0 {
+			delete(ti.postings, g)
+		} else {
+			ti.postings[g] = ids
+		}
+	}
+}
+
+// Match the rows whose text contains every term
+func (ti *TextIndex) Match(terms []string) *TextMatch {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+
+	grams := make([]string, 0)
+	for _, term := range terms {
+		grams = append(grams, bigrams(term)...)
+	}
+
+	m := &TextMatch{Terms: terms, ids: make(map[int64]struct{})}
+	check := func(id int64) {
+		for _, term := range terms {
+			if !strings.Contains(ti.texts[id], term) {
+				return
+			}
+		}
+		m.ids[id] = struct{}{}
+	}
+
+	// single rune terms have no bigram, check every row
+	if len(grams) == 0 {
+		for id := range ti.texts {
+			check(id)
+		}
+		return m
+	}
+
+	lists := make([][]int64, 0, len(grams))
+	for _, g := range grams {
+		ids, ok := ti.postings[g]
+		if !ok {
+			return m
+		}
+		lists = append(lists, ids)
+	}
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+	for _, id := range lists[0] {
+		in := true
+		for _, ids := range lists[1:] {
+			i := sort.Search(len(ids), func(i int) bool { return ids[i] >= id })
+			if i == len(ids) || ids[i] != id {
+				in = false
+				break
+			}
+		}
+		if in {
+			check(id)
+		}
+	}
+	return m
+}
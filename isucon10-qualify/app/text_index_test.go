@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestTextIndexMatch(t *testing.T) {
+	ti := NewTextIndex()
+	ti.Load([]textDoc{
+		{1, "ゲーミングチェア\n長時間でも疲れない"},
+		{2, "ＯＦＦＩＣＥ Chair\nオフィス向け"},
+		{3, "座椅子\nふかふかの座面"},
+		{4, "office desk\n50%オフ"},
+		{5, "チェア\nゲーミング用ではない"},
+	})
+	// replaced and removed docs leave no stale postings
+	ti.Add(textDoc{5, "ハンモック\nゆらゆら"})
+	ti.Add(textDoc{6, "ゲーミングチェア\n限定"})
+	ti.Remove(6)
+
+	tests := []struct {
+		q    string
+		want []int64
+	}{
+		{"ゲーミング", []int64{1}},
+		{"チェア", []int64{1}},
+		{"office", []int64{2, 4}},
+		{"ＯＦＦＩＣＥ chair", []int64{2}},
+		{"office オフ", []int64{2, 4}},
+		{"50%", []int64{4}},
+		{"椅", []int64{3}},
+		{"% ｄ", []int64{4}},
+		{"ゆらゆら", []int64{5}},
+		{"限定", []int64{}},
+		{"座面 ふかふか", []int64{3}},
+		{"ふかふか 長時間", []int64{}},
+		// bigrams of both terms appear in 1 and 3, the terms only in neither
+		{"ゲーミング座椅子", []int64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.q, func(t *testing.T) {
+			terms, err := parseTextQuery(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := ti.Match(terms)
+			got := make([]int64, 0)
+			for id := int64(1); id <= 6; id++ {
+				if m.match(id) {
+					got = append(got, id)
+				}
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Match(%q) = %v, want %v", tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTextQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want []string
+		err  bool
+	}{
+		{q: "ゲーミング", want: []string{"ゲーミング"}},
+		{q: "  ＧＡＭＩＮＧ　Chair ", want: []string{"gaming", "chair"}},
+		{q: "   ", err: true},
+		{q: "椅", want: []string{"椅"}},
+		{q: "チェア Ａ", want: []string{"チェア", "a"}},
+		{q: string(make([]rune, maxTextQuery+1)), err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTextQuery(tt.q)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseTextQuery(%q) = %q, want an error", tt.q, got)
+			}
+			continue
+		}
+		if err != nil || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("parseTextQuery(%q) = %q, %v, want %q", tt.q, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// page bounds of the search endpoints
+const (
+	minPerPage = 1
+	maxPerPage = 100
+	maxPage    = 10000
+)
+
+// nearby search bounds, k is defaultNearbyK when only radiusKm is given
+const (
+	maxNearbyKm    = 100
+	minNearbyK     = 1
+	maxNearbyK     = 100
+	defaultNearbyK = 50
+)
+
+// invalid a 400 for a request value that failed validation
+func invalid(field, format string, args ...interface{}) error {
+	return &APIError{
+		Status:  http.StatusBadRequest,
+		Code:    "invalid_parameter",
+		Message: fmt.Sprintf(format, args...),
+		Field:   field,
+	}
+}
+
+// validateInt a decimal integer within [min, max]
+func validateInt(field, value string, min, max int) (int, error) {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, invalid(field, "must be an integer")
+	}
+	if v < min || v > max {
+		return 0, invalid(field, "must be between %d and %d", min, max)
+	}
+	return v, nil
+}
+
+// validateFloat a decimal number within [min, max]
+func validateFloat(field, value string, min, max float64) (float64, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) {
+		return 0, invalid(field, "must be a number")
+	}
+	if v < min || v > max {
+		return 0, invalid(field, "must be between %g and %g", min, max)
+	}
+	return v, nil
+}
+
+// validateBBox minLongitude,minLatitude,maxLongitude,maxLatitude as in GeoJSON
+func validateBBox(field, value string) (BoundingBox, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return BoundingBox{}, invalid(field, "must be minLongitude,minLatitude,maxLongitude,maxLatitude")
+	}
+	var v [4]float64
+	for i, p := range parts {
+		max := 180.0
+		if i%2 == 1 {
+			max = 90
+		}
+		f, err := validateFloat(field, p, -max, max)
+		if err != nil {
+			return BoundingBox{}, err
+		}
+		v[i] = f
+	}
+	if v[0] > v[2] || v[1] > v[3] {
+		return BoundingBox{}, invalid(field, "min must not exceed max")
+	}
+	return BoundingBox{
+		TopLeftCorner:     Coordinate{Latitude: v[1], Longitude: v[0]},
+		BottomRightCorner: Coordinate{Latitude: v[3], Longitude: v[2]},
+	}, nil
+}
+
+// validateID an integer row id, ids no row has, e.g. 0, are left to the
+// not found answers
+func validateID(field, value string) (int64, error) {
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, invalid(field, "must be an integer")
+	}
+	return id, nil
+}
+
+// validateRangeIDs comma separated IDs of cond's ranges, see getRangeMask
+func validateRangeIDs(field string, cond RangeCondition, value string) (uint64, error) {
+	mask, err := getRangeMask(cond, value)
+	if err != nil {
+		return 0, invalid(field, "unknown range id in %q", value)
+	}
+	return mask, nil
+}
+
+// validateList comma separated values of cond's list
+func validateList(field string, cond ListCondition, value string) (listFilter, error) {
+	f, err := getListFilter(cond, value)
+	if err != nil {
+		return nil, invalid(field, "%s", err)
+	}
+	return f, nil
+}
+
+// validateFeatures comma separated features of cond's list
+func validateFeatures(field string, cond ListCondition, value string) (featureFilter, error) {
+	features := strings.Split(value, ",")
+	for _, f := range features {
+		if indexOf(cond.List, f) < 0 {
+			return featureFilter{}, invalid(field, "unknown feature %q", f)
+		}
+	}
+	return newFeatureFilter(cond.List, features), nil
+}
+
+// validateEmail a non-empty email
+func validateEmail(field, value string) (string, error) {
+	if strings.TrimSpace(value) == "" {
+		return "", invalid(field, "is required")
+	}
+	return value, nil
+}